@@ -1,17 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
 	"github.com/bibin-skaria/ossb/engine"
+	"github.com/bibin-skaria/ossb/internal/config"
 	_ "github.com/bibin-skaria/ossb/executors"
 	_ "github.com/bibin-skaria/ossb/exporters"
 	_ "github.com/bibin-skaria/ossb/frontends/dockerfile"
+	_ "github.com/bibin-skaria/ossb/frontends/gateway"
 	"github.com/bibin-skaria/ossb/internal/types"
 )
 
@@ -22,7 +27,9 @@ var (
 )
 
 func main() {
-	if err := newRootCommand().Execute(); err != nil {
+	cmd := newRootCommand()
+	cmd.SetArgs(expandPlatformBuildArgs(os.Args[1:]))
+	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
@@ -39,6 +46,8 @@ caching, pluggable frontends, executors, and exporters.`,
 
 	cmd.AddCommand(newBuildCommand())
 	cmd.AddCommand(newCacheCommand())
+	cmd.AddCommand(newBuildsCommand())
+	cmd.AddCommand(newTranscriptCommand())
 
 	return cmd
 }
@@ -58,6 +67,21 @@ func newBuildCommand() *cobra.Command {
 		registry   string
 		executor   string
 		rootless   bool
+
+		allowPartialPlatforms bool
+		platformBuildArgs     []string
+		platformTagSuffix     bool
+		diskLimit             string
+		transcriptPath        string
+		transcriptKey         string
+		timestampURL          string
+		layoutDir             string
+		configPath            string
+		streamPush            bool
+		artifactType          string
+		artifactPaths         []string
+		artifactTags          []string
+		baseImageMetadata     string
 	)
 
 	cmd := &cobra.Command{
@@ -96,10 +120,61 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 				}
 			}
 
+			platformBuildArgsMap := make(map[string]map[string]string)
+			for _, arg := range platformBuildArgs {
+				parts := strings.SplitN(arg, ":", 2)
+				if len(parts) != 2 || parts[0] == "" {
+					return fmt.Errorf("invalid platform build arg %q, expected PLATFORM:KEY=VALUE", arg)
+				}
+
+				platform := types.ParsePlatform(parts[0]).String()
+				if platformBuildArgsMap[platform] == nil {
+					platformBuildArgsMap[platform] = make(map[string]string)
+				}
+
+				kv := strings.SplitN(parts[1], "=", 2)
+				if kv[0] == "" {
+					return fmt.Errorf("invalid platform build arg %q, expected PLATFORM:KEY=VALUE", arg)
+				}
+				if len(kv) == 2 {
+					platformBuildArgsMap[platform][kv[0]] = kv[1]
+				} else {
+					platformBuildArgsMap[platform][kv[0]] = ""
+				}
+			}
+
+			if layoutDir != "" {
+				if layoutDir, err = filepath.Abs(layoutDir); err != nil {
+					return fmt.Errorf("failed to resolve OCI layout path: %v", err)
+				}
+			}
+
+			if (transcriptKey != "" || timestampURL != "") && transcriptPath == "" {
+				return fmt.Errorf("--transcript-key and --timestamp-url require --transcript")
+			}
+			if timestampURL != "" && transcriptKey == "" {
+				return fmt.Errorf("--timestamp-url requires --transcript-key")
+			}
+
+			var limits types.ResourceLimits
+			if diskLimit != "" {
+				disk, err := engine.ParseSize(diskLimit)
+				if err != nil {
+					return fmt.Errorf("invalid --disk-limit: %v", err)
+				}
+				limits.Disk = disk
+			}
+
 			var targetPlatforms []types.Platform
 			if len(platforms) > 0 {
+				seen := make(map[string]bool)
 				for _, platform := range platforms {
-					targetPlatforms = append(targetPlatforms, types.ParsePlatform(platform))
+					p := types.ParsePlatform(platform)
+					if seen[p.String()] {
+						continue
+					}
+					seen[p.String()] = true
+					targetPlatforms = append(targetPlatforms, p)
 				}
 			} else {
 				targetPlatforms = []types.Platform{types.GetHostPlatform()}
@@ -114,7 +189,24 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 				executor = "rootless"
 			}
 
-			config := &types.BuildConfig{
+			// Without --executor the builder picks one from the platforms;
+			// an explicit choice is passed through so the executor policy
+			// can reject it instead of silently using another.
+			requestedExecutor := ""
+			if cmd.Flags().Changed("executor") || rootless {
+				requestedExecutor = executor
+			}
+
+			operatorConfig, err := config.Load(configPath)
+			if err != nil {
+				return err
+			}
+
+			if baseImageMetadata == "" {
+				baseImageMetadata = operatorConfig.BaseImageMetadataURL
+			}
+
+			buildConfig := &types.BuildConfig{
 				Context:    absContext,
 				Dockerfile: dockerfile,
 				Tags:       tags,
@@ -128,19 +220,53 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 				Push:       push,
 				Registry:   registry,
 				Rootless:   rootless,
+
+				AllowPartialPlatforms: allowPartialPlatforms,
+				PlatformBuildArgs:     platformBuildArgsMap,
+				PlatformTagSuffix:     platformTagSuffix,
+				Limits:                limits,
+				TranscriptPath:        transcriptPath,
+				TranscriptKey:         transcriptKey,
+				TimestampURL:          timestampURL,
+				LayoutDir:             layoutDir,
+				Executor:              requestedExecutor,
+				AllowedExecutors:      operatorConfig.AllowedExecutors,
+				StreamPush:            streamPush,
+				ArtifactType:          artifactType,
+				ArtifactPaths:         artifactPaths,
+				ArtifactTags:          artifactTags,
+				BaseImageMetadataURL:  baseImageMetadata,
+				Hooks:                 operatorConfig.Hooks,
 			}
 
-			builder, err := engine.NewBuilder(config)
+			builder, err := engine.NewBuilder(buildConfig)
 			if err != nil {
 				return fmt.Errorf("failed to create builder: %v", err)
 			}
 			defer builder.Cleanup()
 
+			// Ctrl-C, or the SIGTERM Kubernetes sends when a build pod is
+			// deleted, cancels the build; a second signal exits at once.
+			signals := make(chan os.Signal, 2)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+			go func() {
+				<-signals
+				fmt.Fprintf(os.Stderr, "\nCancelling build %s...\n", builder.BuildID())
+				builder.Cancel()
+				<-signals
+				os.Exit(130)
+			}()
+
 			result, err := builder.Build()
 			if err != nil {
 				return fmt.Errorf("build failed: %v", err)
 			}
 
+			if result.Cancelled {
+				return fmt.Errorf("build %s cancelled", result.BuildID)
+			}
+
 			if !result.Success {
 				return fmt.Errorf("build failed: %s", result.Error)
 			}
@@ -155,12 +281,19 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 						status = "✗"
 					}
 					fmt.Printf("  %s %s", status, platformStr)
+					if len(platformResult.Tags) > 0 {
+						fmt.Printf(" [%s]", strings.Join(platformResult.Tags, ", "))
+					}
 					if platformResult.Error != "" {
 						fmt.Printf(" (error: %s)", platformResult.Error)
 					}
 					fmt.Printf("\n")
 				}
 				
+				if len(result.SkippedPlatforms) > 0 {
+					fmt.Printf("Skipped platforms (not in index): %s\n", strings.Join(result.SkippedPlatforms, ", "))
+				}
+
 				if result.ManifestListID != "" {
 					fmt.Printf("Manifest List ID: %s\n", result.ManifestListID)
 				}
@@ -172,12 +305,22 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 			if result.ImageID != "" {
 				fmt.Printf("Image ID: %s\n", result.ImageID)
 			}
+			if result.Digest != "" {
+				fmt.Printf("Digest: %s\n", result.Digest)
+			}
 			
+			if len(result.Warnings) > 0 {
+				fmt.Printf("Warnings:\n")
+				for _, warning := range result.Warnings {
+					fmt.Printf("  ! %s\n", warning)
+				}
+			}
+
 			fmt.Printf("Operations: %d\n", result.Operations)
 			fmt.Printf("Cache hits: %d\n", result.CacheHits)
 			fmt.Printf("Duration: %s\n", result.Duration)
 			
-			if config.Push && result.Success {
+			if buildConfig.Push && result.Success {
 				fmt.Printf("Successfully pushed to registry\n")
 			}
 
@@ -187,7 +330,7 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 
 	cmd.Flags().StringVarP(&dockerfile, "file", "f", "Dockerfile", "Path to the Dockerfile")
 	cmd.Flags().StringArrayVarP(&tags, "tag", "t", []string{}, "Name and optionally a tag in the 'name:tag' format")
-	cmd.Flags().StringVarP(&output, "output", "o", "image", "Output type (image, tar, local, multiarch)")
+	cmd.Flags().StringVarP(&output, "output", "o", "image", "Output type (image, tar, local, multiarch, artifact)")
 	cmd.Flags().StringVar(&frontend, "frontend", "dockerfile", "Frontend type")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Cache directory (default: ~/.ossb/cache)")
 	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Disable caching")
@@ -198,10 +341,56 @@ to the directory containing the Dockerfile and any files referenced by it.`,
 	cmd.Flags().StringVar(&registry, "registry", "", "Registry to push to (required with --push)")
 	cmd.Flags().StringVar(&executor, "executor", "container", "Executor type (local, container, rootless)")
 	cmd.Flags().BoolVar(&rootless, "rootless", false, "Enable rootless mode (requires no root privileges)")
+	cmd.Flags().StringArrayVar(&platformBuildArgs, "platform-build-arg", []string{}, "Per-platform build arguments in PLATFORM:KEY=VALUE format (also accepted as --build-arg:PLATFORM KEY=VALUE)")
+	cmd.Flags().BoolVar(&platformTagSuffix, "platform-tag-suffix", false, "Also tag each platform image with an architecture suffix (e.g. app:1.0-arm64)")
+	cmd.Flags().StringVar(&diskLimit, "disk-limit", "", "Maximum scratch space for the build (e.g. 512M, 10G)")
+	cmd.Flags().StringVar(&artifactType, "artifact-type", "", "artifactType of the OCI artifact (e.g. application/vnd.example.tool.v1)")
+	cmd.Flags().StringArrayVar(&artifactPaths, "artifact", []string{}, "File or directory from the build to package as an OCI artifact, as PATH[:MEDIATYPE]")
+	cmd.Flags().StringArrayVar(&artifactTags, "artifact-tag", []string{}, "Tag for the OCI artifact (defaults to --tag with --output artifact)")
+	cmd.Flags().StringVar(&baseImageMetadata, "base-image-metadata", "", "Metadata service to check base images against for deprecation and end of life (overrides the config file)")
+	cmd.Flags().BoolVar(&streamPush, "stream-push", false, "Upload layers to the registry as soon as each step finishes (requires --push)")
+	cmd.Flags().StringVar(&configPath, "config", "", "Operator config file (default: $OSSB_CONFIG, ~/.ossb/config.json or /etc/ossb/config.json)")
+	cmd.Flags().StringVar(&layoutDir, "oci-layout", "", "OCI layout directory to add the image to (existing images in it are kept)")
+	cmd.Flags().StringVar(&transcriptPath, "transcript", "", "Write a hash-chained transcript of every build step to this file")
+	cmd.Flags().StringVar(&transcriptKey, "transcript-key", "", "PEM private key (ed25519, ECDSA or RSA) used to sign the transcript")
+	cmd.Flags().StringVar(&timestampURL, "timestamp-url", "", "RFC 3161 time-stamping authority URL for the signed transcript")
+	cmd.Flags().BoolVar(&allowPartialPlatforms, "allow-partial-platforms", false, "Export and push an index with only the successful platforms when some platforms fail")
 
 	return cmd
 }
 
+// expandPlatformBuildArgs rewrites Docker-style "--build-arg:linux/arm64 KEY=VALUE"
+// arguments into "--platform-build-arg linux/arm64:KEY=VALUE", since cobra
+// cannot declare flags whose names embed the platform.
+func expandPlatformBuildArgs(args []string) []string {
+	const prefix = "--build-arg:"
+
+	expanded := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			expanded = append(expanded, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, prefix) {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		platform, value := strings.TrimPrefix(arg, prefix), ""
+		if idx := strings.Index(platform, "="); idx >= 0 {
+			platform, value = platform[:idx], platform[idx+1:]
+		} else if i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+
+		expanded = append(expanded, "--platform-build-arg", platform+":"+value)
+	}
+
+	return expanded
+}
+
 func newCacheCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cache",
@@ -243,6 +432,7 @@ func newCacheInfoCommand() *cobra.Command {
 			fmt.Printf("Hit Rate: %.2f%%\n", info.HitRate*100)
 			fmt.Printf("Hits: %d\n", info.Hits)
 			fmt.Printf("Misses: %d\n", info.Misses)
+			fmt.Printf("Held Locks: %d\n", info.HeldLocks)
 
 			return nil
 		},
@@ -302,6 +492,269 @@ func newCachePruneCommand() *cobra.Command {
 	return cmd
 }
 
+func newBuildsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "builds",
+		Short: "Inspect build history",
+		Long:  "Commands for inspecting and comparing builds recorded in the OSSB build history.",
+	}
+
+	cmd.AddCommand(newBuildsListCommand())
+	cmd.AddCommand(newBuildsCompareCommand())
+	cmd.AddCommand(newBuildsCancelCommand())
+
+	return cmd
+}
+
+func newBuildsListCommand() *cobra.Command {
+	var cacheDir string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recorded builds",
+		Long:  "List builds recorded in the build history, oldest first.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cacheDir == "" {
+				homeDir, err := os.UserHomeDir()
+				if err != nil {
+					return fmt.Errorf("failed to get home directory: %v", err)
+				}
+				cacheDir = filepath.Join(homeDir, ".ossb", "cache")
+			}
+
+			builds, err := engine.NewHistory(cacheDir).List()
+			if err != nil {
+				return fmt.Errorf("failed to list builds: %v", err)
+			}
+
+			for _, build := range builds {
+				status := "✓"
+				if build.Cancelled {
+					status = "⊘"
+				} else if !build.Success {
+					status = "✗"
+				}
+				fmt.Printf("%s %s  %s  %s  %d steps, %d cache hits\n",
+					status, build.BuildID, build.StartedAt, build.Duration, len(build.Steps), build.CacheHits)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Cache directory (default: ~/.ossb/cache)")
+
+	return cmd
+}
+
+func newBuildsCancelCommand() *cobra.Command {
+	var cacheDir string
+
+	cmd := &cobra.Command{
+		Use:   "cancel <build-id>",
+		Short: "Cancel a running build",
+		Long: `Cancel a build that is running on this host with the same cache directory.
+The build stops its running steps, pushes nothing and is recorded as cancelled.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cacheDir == "" {
+				homeDir, err := os.UserHomeDir()
+				if err != nil {
+					return fmt.Errorf("failed to get home directory: %v", err)
+				}
+				cacheDir = filepath.Join(homeDir, ".ossb", "cache")
+			}
+
+			if err := engine.CancelRunning(cacheDir, args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Cancelling build %s\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Cache directory (default: ~/.ossb/cache)")
+
+	return cmd
+}
+
+func newBuildsCompareCommand() *cobra.Command {
+	var (
+		cacheDir  string
+		threshold float64
+		jsonOut   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compare <build-id> <build-id>",
+		Short: "Compare two recorded builds",
+		Long: `Compare two builds from the build history, highlighting step duration 
+regressions, cache hit changes, layer size growth and digest changes.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cacheDir == "" {
+				homeDir, err := os.UserHomeDir()
+				if err != nil {
+					return fmt.Errorf("failed to get home directory: %v", err)
+				}
+				cacheDir = filepath.Join(homeDir, ".ossb", "cache")
+			}
+
+			history := engine.NewHistory(cacheDir)
+			before, err := history.Load(args[0])
+			if err != nil {
+				return err
+			}
+			after, err := history.Load(args[1])
+			if err != nil {
+				return err
+			}
+
+			comparison := engine.CompareBuilds(before, after, threshold/100)
+
+			if jsonOut {
+				data, err := json.MarshalIndent(comparison, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to marshal comparison: %v", err)
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			fmt.Printf("Comparing %s -> %s\n", comparison.Before, comparison.After)
+			fmt.Printf("Duration: %s -> %s (%+v)\n", comparison.DurationBefore, comparison.DurationAfter, comparison.DurationDelta())
+			fmt.Printf("Cache hits: %+d\n", comparison.CacheHitsDelta)
+			switch {
+			case comparison.DigestBefore == "" || comparison.DigestAfter == "":
+				fmt.Printf("Image digest: not recorded for both builds\n")
+			case comparison.DigestChanged:
+				fmt.Printf("Image digest: changed (%s -> %s)\n", comparison.DigestBefore, comparison.DigestAfter)
+			default:
+				fmt.Printf("Image digest: unchanged (%s)\n", comparison.DigestAfter)
+			}
+
+			fmt.Printf("\nSteps:\n")
+			for _, step := range comparison.Steps {
+				marker := " "
+				switch {
+				case step.Added:
+					marker = "+"
+				case step.Removed:
+					marker = "-"
+				case step.Regression:
+					marker = "▲"
+				}
+
+				cache := ""
+				if step.CacheBefore != step.CacheAfter && !step.Added && !step.Removed {
+					if step.CacheAfter {
+						cache = " (now cached)"
+					} else {
+						cache = " (cache miss)"
+					}
+				}
+
+				fmt.Printf("  %s [%s] %s: %dms -> %dms%s\n",
+					marker, step.Platform, step.Summary, step.BeforeMillis, step.AfterMillis, cache)
+			}
+
+			fmt.Printf("\nLayer size:\n")
+			for _, platform := range comparison.Platforms {
+				fmt.Printf("  %s: %s -> %s", platform.Platform, formatBytes(platform.SizeBefore), formatBytes(platform.SizeAfter))
+				if platform.SizeAfter > platform.SizeBefore {
+					fmt.Printf(" (+%s)", formatBytes(platform.SizeAfter-platform.SizeBefore))
+				}
+				if platform.DigestChanged() {
+					fmt.Printf(", manifest %s -> %s", platform.DigestBefore, platform.DigestAfter)
+				}
+				fmt.Printf("\n")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Cache directory (default: ~/.ossb/cache)")
+	cmd.Flags().Float64Var(&threshold, "threshold", 20, "Percentage slowdown at which a step is flagged as a regression")
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print the comparison as JSON")
+
+	return cmd
+}
+
+func newTranscriptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "transcript",
+		Short: "Work with signed build transcripts",
+		Long:  "Commands for checking build transcripts written with --transcript.",
+	}
+
+	var publicKeyPath, configPath string
+	verifyCmd := &cobra.Command{
+		Use:   "verify <file>",
+		Short: "Verify a build transcript",
+		Long: `Recompute the hash chain of a build transcript and check its signature 
+against the embedded public key. The signer is only verified if the key 
+matches --public-key or a fingerprint pinned in the operator config.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			operatorConfig, err := config.Load(configPath)
+			if err != nil {
+				return err
+			}
+
+			trustedKeys := operatorConfig.TrustedTranscriptKeys
+			if publicKeyPath != "" {
+				data, err := os.ReadFile(publicKeyPath)
+				if err != nil {
+					return fmt.Errorf("failed to read public key: %v", err)
+				}
+				fingerprint, err := engine.PublicKeyFingerprint(data)
+				if err != nil {
+					return fmt.Errorf("invalid --public-key: %v", err)
+				}
+				trustedKeys = []string{fingerprint}
+			}
+
+			transcript, err := engine.VerifyTranscript(args[0], trustedKeys)
+			if err != nil {
+				return fmt.Errorf("transcript verification failed: %v", err)
+			}
+
+			// An unsigned transcript only shows it wasn't edited after the
+			// fact; anyone could have written it.
+			status := "OK"
+			if transcript.Signature == "" {
+				status = "UNSIGNED"
+			}
+			fmt.Printf("Transcript %s: build %s, %d steps\n", status, transcript.Summary.BuildID, len(transcript.Entries))
+			fmt.Printf("Chain head: %s\n", transcript.Summary.ChainHead)
+			if transcript.Summary.Digest != "" {
+				fmt.Printf("Image digest: %s\n", transcript.Summary.Digest)
+			}
+			if transcript.Signature != "" {
+				fmt.Printf("Signature: valid (%s)\n", transcript.Algorithm)
+				if transcript.SignerTrusted() {
+					fmt.Printf("Signer: trusted key %s\n", transcript.KeyFingerprint())
+				} else {
+					fmt.Printf("Signer: UNVERIFIED key %s (pass --public-key or pin it in trusted_transcript_keys)\n", transcript.KeyFingerprint())
+				}
+			} else {
+				fmt.Printf("Signature: none (the chain is intact, but nothing proves who produced it or which image it describes)\n")
+			}
+			if transcript.Timestamp != "" {
+				fmt.Printf("Timestamp: RFC 3161 token present (verify with openssl ts -verify)\n")
+			}
+
+			return nil
+		},
+	}
+	verifyCmd.Flags().StringVar(&publicKeyPath, "public-key", "", "PEM public key the transcript must be signed with (overrides trusted_transcript_keys in the config)")
+	verifyCmd.Flags().StringVar(&configPath, "config", "", "Operator config file (default: $OSSB_CONFIG, ~/.ossb/config.json or /etc/ossb/config.json)")
+	cmd.AddCommand(verifyCmd)
+
+	return cmd
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BaseImageStatus is what a metadata source knows about a base image's
+// support lifecycle.
+type BaseImageStatus struct {
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	EOL         string `json:"eol,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// BaseImageChecker looks up a resolved base image reference. It returns nil
+// when it has nothing to say about the image.
+type BaseImageChecker interface {
+	Check(image string) (*BaseImageStatus, error)
+}
+
+var (
+	baseImageCheckersMu sync.Mutex
+	baseImageCheckers   = make(map[string]BaseImageChecker)
+)
+
+// RegisterBaseImageChecker adds a source every build consults for base
+// image deprecation and end-of-life information. It is safe to call while
+// builds are running.
+func RegisterBaseImageChecker(name string, checker BaseImageChecker) {
+	baseImageCheckersMu.Lock()
+	defer baseImageCheckersMu.Unlock()
+	baseImageCheckers[name] = checker
+}
+
+func init() {
+	RegisterBaseImageChecker("builtin", builtinBaseImages)
+}
+
+// eolWarningWindow is how far ahead of an end-of-life date builds start
+// warning about it.
+const eolWarningWindow = 90 * 24 * time.Hour
+
+// warning describes the status as a build warning, or returns "" if the
+// image is supported and not close to its end of life.
+func (s *BaseImageStatus) warning(image string, now time.Time) string {
+	var problem string
+	if s.EOL != "" {
+		eol, err := time.Parse("2006-01-02", s.EOL)
+		switch {
+		case err != nil:
+			problem = fmt.Sprintf("reaches end of life on %s", s.EOL)
+		case !now.Before(eol):
+			problem = fmt.Sprintf("reached end of life on %s", s.EOL)
+		case eol.Sub(now) <= eolWarningWindow:
+			problem = fmt.Sprintf("reaches end of life on %s", s.EOL)
+		}
+	}
+	if problem == "" && s.Deprecated {
+		problem = "is deprecated"
+	}
+	if problem == "" {
+		return ""
+	}
+
+	warning := fmt.Sprintf("base image %s %s", image, problem)
+	if s.Message != "" {
+		warning += ": " + s.Message
+	}
+	if s.Replacement != "" {
+		warning += fmt.Sprintf(" (consider %s)", s.Replacement)
+	}
+	return warning
+}
+
+// checkBaseImages asks the metadata endpoint, if one is configured, and
+// then each registered checker about every base image; the first one that
+// knows an image decides. Lookup failures become warnings, never build
+// failures.
+func checkBaseImages(images []string, endpoint string, now time.Time) (map[string]string, []string) {
+	baseImageCheckersMu.Lock()
+	registered := make(map[string]BaseImageChecker, len(baseImageCheckers))
+	names := make([]string, 0, len(baseImageCheckers))
+	for name, checker := range baseImageCheckers {
+		registered[name] = checker
+		names = append(names, name)
+	}
+	baseImageCheckersMu.Unlock()
+	sort.Strings(names)
+
+	// The operator's endpoint goes first so it can override the built-in
+	// list, e.g. for an image covered by extended support.
+	checkers := []BaseImageChecker{}
+	if endpoint != "" {
+		checkers = append(checkers, NewEndpointChecker(endpoint))
+		names = append([]string{"endpoint"}, names...)
+	}
+	for _, name := range names[len(checkers):] {
+		checkers = append(checkers, registered[name])
+	}
+
+	statuses := make(map[string]string)
+	var warnings []string
+	for _, image := range images {
+		for i, checker := range checkers {
+			status, err := checker.Check(image)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("base image check (%s) for %s failed: %v", names[i], image, err))
+				continue
+			}
+			if status == nil {
+				continue
+			}
+			if warning := status.warning(image, now); warning != "" {
+				statuses[image] = warning
+				warnings = append(warnings, warning)
+			}
+			break
+		}
+	}
+
+	return statuses, warnings
+}
+
+// EndpointChecker asks an HTTP metadata service about base images. It
+// sends GET <endpoint>?image=<reference> and expects a JSON
+// BaseImageStatus, or 404 if the service doesn't know the image.
+type EndpointChecker struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewEndpointChecker(endpoint string) *EndpointChecker {
+	return &EndpointChecker{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *EndpointChecker) Check(image string) (*BaseImageStatus, error) {
+	target, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata endpoint: %v", err)
+	}
+	query := target.Query()
+	query.Set("image", image)
+	target.RawQuery = query.Encode()
+
+	resp, err := c.client.Get(target.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var status BaseImageStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &status, nil
+}
+
+type baseImageRelease struct {
+	repository  string
+	tags        []string
+	eol         string
+	deprecated  bool
+	replacement string
+}
+
+type builtinChecker []baseImageRelease
+
+// builtinBaseImages covers the official images people most often leave
+// pinned to a release that has stopped getting security fixes. A metadata
+// endpoint can cover anything else.
+var builtinBaseImages = builtinChecker{
+	{repository: "centos", deprecated: true, replacement: "almalinux or rockylinux"},
+	{repository: "openjdk", deprecated: true, replacement: "eclipse-temurin"},
+
+	{repository: "debian", tags: []string{"8", "jessie"}, eol: "2020-06-30", replacement: "debian:bookworm"},
+	{repository: "debian", tags: []string{"9", "stretch"}, eol: "2022-06-30", replacement: "debian:bookworm"},
+	{repository: "debian", tags: []string{"10", "buster"}, eol: "2024-06-30", replacement: "debian:bookworm"},
+	{repository: "debian", tags: []string{"11", "bullseye"}, eol: "2026-08-31", replacement: "debian:bookworm"},
+
+	{repository: "ubuntu", tags: []string{"14.04", "trusty"}, eol: "2019-04-30", replacement: "ubuntu:24.04"},
+	{repository: "ubuntu", tags: []string{"16.04", "xenial"}, eol: "2021-04-30", replacement: "ubuntu:24.04"},
+	{repository: "ubuntu", tags: []string{"18.04", "bionic"}, eol: "2023-05-31", replacement: "ubuntu:24.04"},
+	{repository: "ubuntu", tags: []string{"20.04", "focal"}, eol: "2025-05-31", replacement: "ubuntu:24.04"},
+
+	{repository: "alpine", tags: []string{"3.15"}, eol: "2023-11-01", replacement: "alpine:3.22"},
+	{repository: "alpine", tags: []string{"3.16"}, eol: "2024-05-23", replacement: "alpine:3.22"},
+	{repository: "alpine", tags: []string{"3.17"}, eol: "2024-11-22", replacement: "alpine:3.22"},
+	{repository: "alpine", tags: []string{"3.18"}, eol: "2025-05-09", replacement: "alpine:3.22"},
+	{repository: "alpine", tags: []string{"3.19"}, eol: "2025-11-01", replacement: "alpine:3.22"},
+	{repository: "alpine", tags: []string{"3.20"}, eol: "2026-04-01", replacement: "alpine:3.22"},
+
+	{repository: "node", tags: []string{"12"}, eol: "2022-04-30", replacement: "node:22"},
+	{repository: "node", tags: []string{"14"}, eol: "2023-04-30", replacement: "node:22"},
+	{repository: "node", tags: []string{"16"}, eol: "2023-09-11", replacement: "node:22"},
+	{repository: "node", tags: []string{"18"}, eol: "2025-04-30", replacement: "node:22"},
+	{repository: "node", tags: []string{"20"}, eol: "2026-04-30", replacement: "node:22"},
+
+	{repository: "python", tags: []string{"2", "2.7"}, eol: "2020-01-01", replacement: "python:3.13"},
+	{repository: "python", tags: []string{"3.6"}, eol: "2021-12-23", replacement: "python:3.13"},
+	{repository: "python", tags: []string{"3.7"}, eol: "2023-06-27", replacement: "python:3.13"},
+	{repository: "python", tags: []string{"3.8"}, eol: "2024-10-07", replacement: "python:3.13"},
+	{repository: "python", tags: []string{"3.9"}, eol: "2025-10-31", replacement: "python:3.13"},
+}
+
+func (c builtinChecker) Check(image string) (*BaseImageStatus, error) {
+	repository, tag := splitImageTag(image)
+
+	for _, release := range c {
+		if release.repository != repository {
+			continue
+		}
+		if len(release.tags) > 0 && !matchesReleaseTag(tag, release.tags) {
+			continue
+		}
+		return &BaseImageStatus{
+			Deprecated:  release.deprecated,
+			EOL:         release.eol,
+			Replacement: release.replacement,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// splitImageTag returns the repository of an official Docker Hub image
+// without its docker.io/library/ prefix, and the tag ("latest" if none).
+func splitImageTag(image string) (string, string) {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		image = image[:idx]
+	}
+
+	repository, tag := image, "latest"
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		repository, tag = image[:idx], image[idx+1:]
+	}
+
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "library/"} {
+		repository = strings.TrimPrefix(repository, prefix)
+	}
+	return repository, tag
+}
+
+// matchesReleaseTag reports whether tag is one of releases or a variant of
+// one, e.g. "3.8-slim" or "18.20.4-alpine" for releases "3.8" and "18".
+func matchesReleaseTag(tag string, releases []string) bool {
+	for _, release := range releases {
+		if tag == release {
+			return true
+		}
+		if strings.HasPrefix(tag, release) && strings.ContainsAny(tag[len(release):len(release)+1], ".-") {
+			return true
+		}
+	}
+	return false
+}
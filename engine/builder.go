@@ -1,11 +1,15 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bibin-skaria/ossb/executors"
@@ -21,8 +25,24 @@ type Builder struct {
 	executor    executors.Executor
 	exporter    exporters.Exporter
 	frontend    frontends.Frontend
+	history     *History
+	quota       *DiskQuota
+	transcript  *Transcript
+	streamer    *LayerStreamer
+	buildID     string
 	workDir     string
 	progressOut io.Writer
+	cancelled   atomic.Bool
+
+	// releaseMu orders Cancel against Cleanup, so a cancel arriving after
+	// the workspace was released can't leave it marked cancelled.
+	releaseMu sync.Mutex
+	released  bool
+
+	// hookCtx is cancelled with the build so running hooks are stopped
+	// along with its processes.
+	hookCtx     context.Context
+	cancelHooks context.CancelFunc
 }
 
 func NewBuilder(config *types.BuildConfig) (*Builder, error) {
@@ -38,8 +58,33 @@ func NewBuilder(config *types.BuildConfig) (*Builder, error) {
 		return nil, fmt.Errorf("failed to create cache directory: %v", err)
 	}
 
-	workDir := filepath.Join(config.CacheDir, "work", fmt.Sprintf("build-%d", time.Now().Unix()))
-	if err := os.MkdirAll(workDir, 0755); err != nil {
+	executorType, err := selectExecutor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateHooks(config.Hooks); err != nil {
+		return nil, err
+	}
+
+	if config.Push && config.Registry == "" {
+		return nil, fmt.Errorf("pushing requires a registry")
+	}
+	if config.Push && config.Output != "artifact" && len(config.Tags) == 0 {
+		return nil, fmt.Errorf("pushing requires a tag")
+	}
+	if err := exporters.ValidateArtifact(config); err != nil {
+		return nil, err
+	}
+
+	// The work directory name is the build ID. MkdirTemp adds a random
+	// suffix, so builds started in the same instant never share one.
+	workRoot := filepath.Join(config.CacheDir, "work")
+	if err := os.MkdirAll(workRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	workDir, err := os.MkdirTemp(workRoot, fmt.Sprintf("build-%d-", time.Now().UnixNano()))
+	if err != nil {
 		return nil, fmt.Errorf("failed to create work directory: %v", err)
 	}
 
@@ -56,13 +101,6 @@ func NewBuilder(config *types.BuildConfig) (*Builder, error) {
 		return nil, fmt.Errorf("failed to get frontend: %v", err)
 	}
 
-	executorType := "local"
-	if config.Rootless {
-		executorType = "rootless"
-	} else if len(config.Platforms) > 1 || (len(config.Platforms) == 1 && config.Platforms[0].String() != types.GetHostPlatform().String()) {
-		executorType = "container"
-	}
-
 	executor, err := executors.GetExecutor(executorType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get executor %s: %v", executorType, err)
@@ -73,6 +111,38 @@ func NewBuilder(config *types.BuildConfig) (*Builder, error) {
 		return nil, fmt.Errorf("failed to get exporter: %v", err)
 	}
 
+	var transcript *Transcript
+	if config.TranscriptPath != "" {
+		transcript, err = NewTranscript(config.TranscriptKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up build transcript: %v", err)
+		}
+	}
+
+	var streamer *LayerStreamer
+	if config.StreamPush {
+		if !config.Push || config.Registry == "" || len(config.Tags) == 0 {
+			return nil, fmt.Errorf("streaming push requires --push, --registry and a tag")
+		}
+		if config.Output != "image" || len(config.Platforms) > 1 {
+			return nil, fmt.Errorf("streaming push is only supported for single-platform image output")
+		}
+		streamer, err = NewLayerStreamer(exporters.PushReference(config.Tags[0], config.Registry), filepath.Join(workDir, "stream-blobs"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up streaming push: %v", err)
+		}
+	}
+
+	var quota *DiskQuota
+	if config.Limits.Disk > 0 {
+		quota, err = NewDiskQuota(workDir, config.Limits.Disk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up disk quota: %v", err)
+		}
+	}
+
+	hookCtx, cancelHooks := context.WithCancel(context.Background())
+
 	return &Builder{
 		config:      config,
 		cache:       cache,
@@ -80,8 +150,15 @@ func NewBuilder(config *types.BuildConfig) (*Builder, error) {
 		executor:    executor,
 		exporter:    exporter,
 		frontend:    frontend,
+		history:     NewHistory(config.CacheDir),
+		quota:       quota,
+		transcript:  transcript,
+		streamer:    streamer,
+		buildID:     filepath.Base(workDir),
 		workDir:     workDir,
 		progressOut: os.Stdout,
+		hookCtx:     hookCtx,
+		cancelHooks: cancelHooks,
 	}, nil
 }
 
@@ -96,7 +173,17 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 		Success:         false,
 		Metadata:        make(map[string]string),
 		PlatformResults: make(map[string]*types.PlatformResult),
+		BuildID:         b.buildID,
+		StartedAt:       start.Format(time.RFC3339),
 	}
+	// A second Build on the same builder would share its ID, work
+	// directory and history entry, so it fails before touching any of them.
+	if err := registerBuild(b); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	defer unregisterBuild(b)
+	defer b.finishBuild(result, start)
 
 	if len(b.config.Platforms) == 0 {
 		b.config.Platforms = []types.Platform{types.GetHostPlatform()}
@@ -104,12 +191,30 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 
 	result.MultiArch = len(b.config.Platforms) > 1
 
+	if err := b.runHooks(result, HookPreBuild, nil); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
 	if b.config.Progress && b.progressOut != nil {
 		if result.MultiArch {
 			fmt.Fprintf(b.progressOut, "Starting multi-arch build for %d platforms...\n", len(b.config.Platforms))
 		} else {
 			fmt.Fprintf(b.progressOut, "Starting build for %s...\n", b.config.Platforms[0].String())
 		}
+		fmt.Fprintf(b.progressOut, "Build ID: %s\n", b.buildID)
+	}
+
+	if b.quota != nil {
+		if b.config.Progress && b.progressOut != nil {
+			fmt.Fprintf(b.progressOut, "Limiting build scratch space to %s (%s)\n", formatSize(b.quota.Limit()), b.quota.Method())
+		}
+		if warning := b.quota.Warning(); warning != "" {
+			result.Warnings = append(result.Warnings, warning)
+			if b.config.Progress && b.progressOut != nil {
+				fmt.Fprintf(b.progressOut, "Warning: %s\n", warning)
+			}
+		}
 	}
 
 	dockerfilePath := filepath.Join(b.config.Context, b.config.Dockerfile)
@@ -125,8 +230,13 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 
 	totalCacheHits := 0
 	allSuccess := true
+	checkedBaseImages := make(map[string]bool)
 	
 	for _, platform := range b.config.Platforms {
+		if b.Cancelled() {
+			break
+		}
+
 		platformResult := &types.PlatformResult{
 			Platform: platform,
 			Success:  false,
@@ -137,7 +247,7 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 			fmt.Fprintf(b.progressOut, "\nBuilding for platform %s...\n", platform.String())
 		}
 
-		operations, err := b.frontend.Parse(string(dockerfileContent), b.config)
+		operations, err := b.frontend.Parse(string(dockerfileContent), b.platformConfig(platform))
 		if err != nil {
 			platformResult.Error = fmt.Sprintf("failed to parse Dockerfile: %v", err)
 			allSuccess = false
@@ -148,6 +258,8 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 			op.Platform = platform
 		}
 
+		b.checkBaseImages(result, operations, checkedBaseImages)
+
 		if b.config.Progress && b.progressOut != nil {
 			fmt.Fprintf(b.progressOut, "Building dependency graph for %d operations on %s...\n", len(operations), platform.String())
 		}
@@ -171,7 +283,16 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 		}
 
 		cacheHits := 0
+		// A failing post-step hook with the "fail" policy on a step that
+		// failed anyway is reported alongside the step's own error.
+		var stepHookErr error
 		for i, nodeID := range executionOrder {
+			if b.Cancelled() {
+				platformResult.Error = "build cancelled"
+				allSuccess = false
+				break
+			}
+
 			operation := solver.GetOperation(nodeID)
 			if operation == nil {
 				platformResult.Error = fmt.Sprintf("operation not found for node %s", nodeID)
@@ -183,7 +304,44 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 				fmt.Fprintf(b.progressOut, "[%s %d/%d] Executing %s operation...\n", platform.String(), i+1, len(executionOrder), operation.Type)
 			}
 
+			stepStart := time.Now()
+			// Without a kernel quota, a step that outgrows the limit has its
+			// processes killed; unlike a cancel, later platforms still run.
+			stopWatch := func() error { return nil }
+			if b.quota != nil {
+				stopWatch = b.quota.Watch(func() { executors.KillProcesses(b.workDir) })
+			}
 			opResult, err := b.executeOperation(operation)
+			quotaErr := stopWatch()
+			step := &types.StepResult{
+				Index:          i,
+				Platform:       platform.String(),
+				Type:           operation.Type,
+				Summary:        describeOperation(operation),
+				CacheKey:       operation.CacheKey(),
+				DurationMillis: time.Since(stepStart).Milliseconds(),
+			}
+			result.Steps = append(result.Steps, step)
+			if b.transcript != nil {
+				b.transcript.Record(step, operation, opResult, err)
+			}
+
+			stepSucceeded := err == nil && opResult.Success
+			if hookErr := b.runHooks(result, HookPostStep, stepHookVars(step, stepSucceeded)); hookErr != nil {
+				if stepSucceeded {
+					platformResult.Error = hookErr.Error()
+					allSuccess = false
+					break
+				}
+				stepHookErr = hookErr
+			}
+
+			if quotaErr != nil {
+				platformResult.Error = fmt.Sprintf("operation failed: %v", quotaErr)
+				allSuccess = false
+				break
+			}
+
 			if err != nil {
 				platformResult.Error = fmt.Sprintf("failed to execute operation: %v", err)
 				allSuccess = false
@@ -192,48 +350,158 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 
 			if !opResult.Success {
 				platformResult.Error = fmt.Sprintf("operation failed: %s", opResult.Error)
+				if b.quota != nil {
+					if quotaErr := b.quota.Explain(opResult.Error); quotaErr != nil {
+						platformResult.Error = fmt.Sprintf("operation failed: %v", quotaErr)
+					}
+				}
 				allSuccess = false
 				break
 			}
 
+			if b.quota != nil {
+				if err := b.quota.Check(); err != nil {
+					platformResult.Error = fmt.Sprintf("operation failed: %v", err)
+					allSuccess = false
+					break
+				}
+			}
+
+			step.Success = true
+			step.CacheHit = opResult.CacheHit
 			if opResult.CacheHit {
 				cacheHits++
 			}
 
+			if b.streamer != nil && !opResult.CacheHit && operation.Type != types.OperationTypeMeta {
+				b.streamer.Snapshot(b.layersDir(platform))
+			}
+
+			for _, warning := range opResult.Warnings {
+				warning = fmt.Sprintf("%s: %s", platform.String(), warning)
+				result.Warnings = append(result.Warnings, warning)
+				if b.config.Progress && b.progressOut != nil {
+					fmt.Fprintf(b.progressOut, "Warning: %s\n", warning)
+				}
+			}
+
 			b.updateResultMetadata(result, operation, opResult)
 		}
 
+		if stepHookErr != nil {
+			platformResult.Error = fmt.Sprintf("%s; %v", platformResult.Error, stepHookErr)
+		}
+
+		platformResult.Size = b.layersSize(platform)
+
+		// A failed platform is never exported, so under a disk limit its
+		// layers are dropped to give the remaining platforms the space.
+		if b.quota != nil && platformResult.Error != "" {
+			os.RemoveAll(filepath.Join(b.workDir, "layers", platform.String()))
+		}
+
 		if platformResult.Error == "" {
 			platformResult.Success = true
 			platformResult.ImageID = fmt.Sprintf("%s-%s", b.config.Tags[0], platform.String())
+			if b.config.PlatformTagSuffix {
+				for _, tag := range b.config.Tags {
+					platformResult.Tags = append(platformResult.Tags, types.PlatformTag(tag, platform))
+				}
+			}
 			totalCacheHits += cacheHits
 		}
 	}
 
+	if b.Cancelled() {
+		b.finishCancelled(result, start)
+		return result, nil
+	}
+
 	result.Operations = len(b.config.Platforms) * result.Operations // Multiply by platform count
 	result.CacheHits = totalCacheHits
 	result.Success = allSuccess
 
 	if !allSuccess {
 		var failedPlatforms []string
-		for platformStr, platformResult := range result.PlatformResults {
-			if !platformResult.Success {
-				failedPlatforms = append(failedPlatforms, platformStr)
+		for _, platform := range b.config.Platforms {
+			if platformResult := result.PlatformResults[platform.String()]; !platformResult.Success {
+				failedPlatforms = append(failedPlatforms, platform.String())
+			}
+		}
+
+		// With --allow-partial-platforms a multi-arch build still exports an
+		// index as long as at least one platform succeeded; the failed ones
+		// are recorded as skipped instead of failing the whole build.
+		if b.config.AllowPartialPlatforms && result.MultiArch && len(failedPlatforms) < len(b.config.Platforms) {
+			result.Success = true
+			result.SkippedPlatforms = failedPlatforms
+			result.Metadata["skipped_platforms"] = strings.Join(failedPlatforms, ",")
+
+			if b.config.Progress && b.progressOut != nil {
+				for _, platformStr := range failedPlatforms {
+					fmt.Fprintf(b.progressOut, "Warning: skipping platform %s: %s\n", platformStr, result.PlatformResults[platformStr].Error)
+				}
 			}
+		} else {
+			var failures []string
+			for _, platformStr := range failedPlatforms {
+				failures = append(failures, fmt.Sprintf("%s (%s)", platformStr, result.PlatformResults[platformStr].Error))
+			}
+			result.Error = fmt.Sprintf("build failed for platforms: %s", strings.Join(failures, ", "))
 		}
-		result.Error = fmt.Sprintf("build failed for platforms: %s", strings.Join(failedPlatforms, ", "))
+	}
+
+	if b.streamer != nil {
+		b.finishStreaming(result)
 	}
 
 	if result.Success {
+		// Exporters also check before pushing, so a cancel that arrives
+		// mid-export stops the push rather than publishing the image.
+		if b.Cancelled() {
+			b.finishCancelled(result, start)
+			return result, nil
+		}
+
 		if b.config.Progress && b.progressOut != nil {
 			fmt.Fprintf(b.progressOut, "Exporting result...\n")
 		}
 
 		if err := b.exporter.Export(result, b.config, b.workDir); err != nil {
+			if b.Cancelled() {
+				b.finishCancelled(result, start)
+				return result, nil
+			}
 			result.Error = fmt.Sprintf("failed to export result: %v", err)
 			result.Success = false
 			return result, nil
 		}
+
+		// Artifact paths alongside another output produce an adjacent OCI
+		// artifact from the same build.
+		if b.config.Output != "artifact" && len(b.config.ArtifactPaths) > 0 {
+			artifactExporter, err := exporters.GetExporter("artifact")
+			if err == nil {
+				err = artifactExporter.Export(result, b.config, b.workDir)
+			}
+			if err != nil {
+				if b.Cancelled() {
+					b.finishCancelled(result, start)
+					return result, nil
+				}
+				result.Error = fmt.Sprintf("failed to export artifact: %v", err)
+				result.Success = false
+				return result, nil
+			}
+		}
+
+		if b.config.Push && b.config.Registry != "" {
+			if err := b.runHooks(result, HookPostPush, pushHookVars(b.config, result)); err != nil {
+				result.Error = err.Error()
+				result.Success = false
+				return result, nil
+			}
+		}
 	}
 
 	result.Duration = time.Since(start).String()
@@ -259,6 +527,29 @@ func (b *Builder) Build() (*types.BuildResult, error) {
 	return result, nil
 }
 
+// platformConfig returns the build config for a single platform: Platforms
+// holds only that platform, and any per-platform build args are layered
+// over the global ones.
+func (b *Builder) platformConfig(platform types.Platform) *types.BuildConfig {
+	config := *b.config
+	config.Platforms = []types.Platform{platform}
+
+	overrides, exists := b.config.PlatformBuildArgs[platform.String()]
+	if !exists {
+		return &config
+	}
+
+	config.BuildArgs = make(map[string]string)
+	for key, value := range b.config.BuildArgs {
+		config.BuildArgs[key] = value
+	}
+	for key, value := range overrides {
+		config.BuildArgs[key] = value
+	}
+
+	return &config
+}
+
 func (b *Builder) executeOperation(operation *types.Operation) (*types.OperationResult, error) {
 	if !b.config.NoCache {
 		cacheKey := operation.CacheKey()
@@ -290,6 +581,10 @@ func (b *Builder) updateResultMetadata(result *types.BuildResult, operation *typ
 		}
 	}
 
+	for key, value := range opResult.Metadata {
+		result.Metadata[key] = value
+	}
+
 	if opResult.Environment != nil {
 		for key, value := range opResult.Environment {
 			result.Metadata["env."+key] = value
@@ -297,6 +592,192 @@ func (b *Builder) updateResultMetadata(result *types.BuildResult, operation *typ
 	}
 }
 
+func (b *Builder) finishBuild(result *types.BuildResult, start time.Time) {
+	if result.Duration == "" {
+		result.Duration = time.Since(start).String()
+	}
+
+	b.recordCacheContention(result)
+
+	if !result.Success && !result.Cancelled {
+		b.runHooks(result, HookOnFailure, map[string]string{"OSSB_ERROR": result.Error})
+	}
+
+	if b.transcript != nil {
+		if err := b.writeTranscript(result); err != nil {
+			result.Success = false
+			result.Error = err.Error()
+		}
+	}
+
+	if err := b.history.Save(result); err != nil {
+		if b.config.Progress && b.progressOut != nil {
+			fmt.Fprintf(b.progressOut, "Warning: failed to record build history: %v\n", err)
+		}
+	}
+}
+
+// recordCacheContention notes in the build metadata how long this build
+// waited on cache entry locks held by other builds sharing the cache.
+func (b *Builder) recordCacheContention(result *types.BuildResult) {
+	waits, waitTime, steals := b.cache.lockWaits, b.cache.lockWaitTime.Milliseconds(), b.cache.lockSteals
+	if waits == 0 && steals == 0 {
+		return
+	}
+
+	result.Metadata["cache.lock_waits"] = fmt.Sprintf("%d", waits)
+	result.Metadata["cache.lock_wait_ms"] = fmt.Sprintf("%d", waitTime)
+	result.Metadata["cache.lock_steals"] = fmt.Sprintf("%d", steals)
+
+	if b.config.Progress && b.progressOut != nil {
+		fmt.Fprintf(b.progressOut, "Cache lock contention: waited %d times (%dms), took over %d stale locks\n", waits, waitTime, steals)
+	}
+}
+
+// finishCancelled marks a build as cancelled and frees its workspace now
+// rather than when the caller gets around to Cleanup.
+func (b *Builder) finishCancelled(result *types.BuildResult, start time.Time) {
+	result.Success = false
+	result.Cancelled = true
+	result.Error = "build cancelled"
+	result.Duration = time.Since(start).String()
+
+	if b.streamer != nil {
+		b.streamer.Finish()
+	}
+
+	if err := b.Cleanup(); err != nil && b.config.Progress && b.progressOut != nil {
+		fmt.Fprintf(b.progressOut, "Warning: failed to release workspace: %v\n", err)
+	}
+
+	if b.config.Progress && b.progressOut != nil {
+		fmt.Fprintf(b.progressOut, "Build cancelled after %s\n", result.Duration)
+	}
+}
+
+func (b *Builder) writeTranscript(result *types.BuildResult) error {
+	if err := b.transcript.Finish(result, b.config.TimestampURL); err != nil {
+		return err
+	}
+
+	if err := b.transcript.WriteFile(b.config.TranscriptPath); err != nil {
+		return err
+	}
+
+	if b.config.Progress && b.progressOut != nil {
+		fmt.Fprintf(b.progressOut, "Build transcript written to %s\n", b.config.TranscriptPath)
+	}
+	return nil
+}
+
+// checkBaseImages looks up the lifecycle status of base images this build
+// hasn't checked yet, skipping scratch, local root filesystems and earlier
+// build stages.
+func (b *Builder) checkBaseImages(result *types.BuildResult, operations []*types.Operation, checked map[string]bool) {
+	stages := make(map[string]bool)
+	var images []string
+	for _, op := range operations {
+		if op.Type != types.OperationTypeSource {
+			continue
+		}
+		image := op.Metadata["image"]
+		local := op.Metadata["source"] != ""
+		if image != "scratch" && !local && !stages[image] && !checked[image] {
+			checked[image] = true
+			images = append(images, image)
+		}
+		if alias := op.Metadata["alias"]; alias != "" {
+			stages[alias] = true
+		}
+	}
+	if len(images) == 0 {
+		return
+	}
+
+	statuses, warnings := checkBaseImages(images, b.config.BaseImageMetadataURL, time.Now())
+	for image, status := range statuses {
+		result.Metadata["base_image."+image] = status
+	}
+
+	for _, warning := range warnings {
+		result.Warnings = append(result.Warnings, warning)
+		if b.config.Progress && b.progressOut != nil {
+			fmt.Fprintf(b.progressOut, "Warning: %s\n", warning)
+		}
+	}
+}
+
+func (b *Builder) finishStreaming(result *types.BuildResult) {
+	uploaded, size, errors := b.streamer.Finish()
+	result.Metadata["stream_push.blobs"] = fmt.Sprintf("%d", uploaded)
+
+	for _, err := range errors {
+		warning := "streaming push: " + err
+		result.Warnings = append(result.Warnings, warning)
+		if b.config.Progress && b.progressOut != nil {
+			fmt.Fprintf(b.progressOut, "Warning: %s\n", warning)
+		}
+	}
+
+	if b.config.Progress && b.progressOut != nil {
+		fmt.Fprintf(b.progressOut, "Streamed %d layer blobs (%s) to the registry during the build\n", uploaded, formatSize(size))
+	}
+
+	// The final push would be rejected the same way, so fail now instead
+	// of exporting an image that can't be pushed.
+	if quota := b.streamer.QuotaError(); quota != nil && result.Success {
+		result.Success = false
+		result.Error = fmt.Sprintf("failed to push: %v", quota)
+		result.Metadata["stream_push.quota_exceeded"] = "true"
+	}
+}
+
+// layersDir returns where the executor put the platform's layers: the
+// container executors keep one directory per platform, the local executor
+// a single one.
+func (b *Builder) layersDir(platform types.Platform) string {
+	layersDir := filepath.Join(b.workDir, "layers", platform.String())
+	if _, err := os.Stat(layersDir); err != nil {
+		layersDir = filepath.Join(b.workDir, "layers")
+	}
+	return layersDir
+}
+
+func (b *Builder) layersSize(platform types.Platform) int64 {
+	var size int64
+	filepath.Walk(b.layersDir(platform), func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func describeOperation(operation *types.Operation) string {
+	switch operation.Type {
+	case types.OperationTypeSource:
+		return "FROM " + operation.Metadata["image"]
+	case types.OperationTypeExec:
+		if len(operation.Command) == 3 && operation.Command[0] == "/bin/sh" && operation.Command[1] == "-c" {
+			return "RUN " + operation.Command[2]
+		}
+		return "RUN " + strings.Join(operation.Command, " ")
+	case types.OperationTypeFile:
+		if len(operation.Command) > 0 {
+			return strings.ToUpper(operation.Command[0]) + " " + operation.Metadata["dest"]
+		}
+	case types.OperationTypeMeta:
+		keys := make([]string, 0, len(operation.Metadata))
+		for key := range operation.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "META " + strings.Join(keys, ",")
+	}
+	return string(operation.Type)
+}
+
 func (b *Builder) GetCacheInfo() (*types.CacheInfo, error) {
 	return b.cache.Info()
 }
@@ -310,6 +791,18 @@ func (b *Builder) ClearCache() error {
 }
 
 func (b *Builder) Cleanup() error {
+	b.releaseMu.Lock()
+	b.released = true
+	executors.ReleaseProcesses(b.workDir)
+	b.releaseMu.Unlock()
+
+	if b.quota != nil {
+		if err := b.quota.Release(); err != nil {
+			return err
+		}
+		b.quota = nil
+	}
+
 	if b.workDir != "" {
 		return os.RemoveAll(b.workDir)
 	}
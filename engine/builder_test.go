@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bibin-skaria/ossb/executors"
+	"github.com/bibin-skaria/ossb/exporters"
+	_ "github.com/bibin-skaria/ossb/frontends/dockerfile"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+func TestDiskQuotaFailsOnlyTheOffendingPlatform(t *testing.T) {
+	contextDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("ARG STEP\nRUN $STEP\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := types.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := types.Platform{OS: "linux", Architecture: "arm64"}
+	layoutDir := t.TempDir()
+
+	// The local executor shares one layer directory across platforms, so
+	// the second platform removes what the first left behind.
+	builder, err := NewBuilder(&types.BuildConfig{
+		Context:               contextDir,
+		Dockerfile:            "Dockerfile",
+		Tags:                  []string{"quota-test:1"},
+		Output:                "multiarch",
+		Frontend:              "dockerfile",
+		CacheDir:              t.TempDir(),
+		NoCache:               true,
+		Platforms:             []types.Platform{amd64, arm64},
+		Executor:              "local",
+		LayoutDir:             layoutDir,
+		AllowPartialPlatforms: true,
+		Limits:                types.ResourceLimits{Disk: 1 << 20},
+		PlatformBuildArgs: map[string]map[string]string{
+			amd64.String(): {"STEP": "head -c 4194304 /dev/zero > big; sleep 30"},
+			arm64.String(): {"STEP": "rm -f big; echo ok > small"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Cleanup()
+
+	if builder.quota.Method() != quotaMethodWalk {
+		t.Skipf("disk limit is enforced by %s, not measured", builder.quota.Method())
+	}
+
+	result, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success {
+		t.Fatalf("build failed: %s", result.Error)
+	}
+	if failed := result.PlatformResults[amd64.String()]; failed.Success || !strings.Contains(failed.Error, "disk quota exceeded") {
+		t.Fatalf("expected %s to fail on the disk limit, got %+v", amd64, failed)
+	}
+	if !result.PlatformResults[arm64.String()].Success {
+		t.Fatalf("expected %s to build, got %s", arm64, result.PlatformResults[arm64.String()].Error)
+	}
+	if executors.Cancelled(builder.workDir) {
+		t.Fatal("exceeding the disk limit marked the build cancelled")
+	}
+
+	layout, err := exporters.OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(layout.BlobPath(result.ManifestListID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index exporters.OCIIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Platform == nil || index.Manifests[0].Platform.Architecture != arm64.Architecture {
+		t.Fatalf("expected only %s to be exported, got %s", arm64, data)
+	}
+}
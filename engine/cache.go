@@ -17,6 +17,10 @@ type Cache struct {
 	baseDir string
 	hits    int64
 	misses  int64
+
+	lockWaits    int64
+	lockWaitTime time.Duration
+	lockSteals   int64
 }
 
 type CacheEntry struct {
@@ -88,8 +92,21 @@ func (c *Cache) Set(key string, result *types.OperationResult) error {
 
 	entry.Size = int64(len(data))
 	entryPath := c.getEntryPath(key)
-	
-	if err := os.WriteFile(entryPath, data, 0644); err != nil {
+
+	unlock, err := c.lockKey(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Write to a temporary file and rename it into place so concurrent
+	// readers never see a partial entry.
+	tmpPath := fmt.Sprintf("%s.tmp-%d", entryPath, os.Getpid())
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	if err := os.Rename(tmpPath, entryPath); err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("failed to write cache entry: %v", err)
 	}
 
@@ -100,6 +117,10 @@ func (c *Cache) Info() (*types.CacheInfo, error) {
 	info := &types.CacheInfo{
 		Hits:   c.hits,
 		Misses: c.misses,
+
+		LockWaits:      c.lockWaits,
+		LockWaitMillis: c.lockWaitTime.Milliseconds(),
+		LockSteals:     c.lockSteals,
 	}
 
 	if c.hits+c.misses > 0 {
@@ -119,6 +140,10 @@ func (c *Cache) Info() (*types.CacheInfo, error) {
 			totalSize += fileInfo.Size()
 		}
 
+		if !fileInfo.IsDir() && strings.HasSuffix(path, ".json.lock") {
+			info.HeldLocks++
+		}
+
 		return nil
 	})
 
@@ -226,12 +251,30 @@ func (c *Cache) PrunePlatform(platform types.Platform) error {
 
 func (c *Cache) Prune() error {
 	cutoff := time.Now().Add(-24 * time.Hour) 
+	host, _ := os.Hostname()
 
 	err := filepath.Walk(c.baseDir, func(path string, fileInfo os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 
+		if !fileInfo.IsDir() && strings.HasSuffix(path, ".json.lock") {
+			if data, err := os.ReadFile(path); err == nil && lockIsStale(data, fileInfo.ModTime(), host) {
+				os.Remove(path)
+			}
+			return nil
+		}
+
+		// Locks set aside while stealing, and entries a crashed build
+		// never renamed into place. Their writer may be on another host
+		// sharing the cache, so only age tells that they are abandoned.
+		if !fileInfo.IsDir() && (strings.Contains(path, ".json.lock.stale-") || strings.Contains(path, ".json.tmp-")) {
+			if time.Since(fileInfo.ModTime()) > cacheLockStaleAfter {
+				os.Remove(path)
+			}
+			return nil
+		}
+
 		if !fileInfo.IsDir() && strings.HasSuffix(path, ".json") {
 			if fileInfo.ModTime().Before(cutoff) {
 				if err := os.Remove(path); err != nil {
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// cacheLockStaleAfter is how old a lock can get before it is assumed
+	// to belong to a crashed build. Holders only keep a lock while writing
+	// one small entry, so anything this old is long dead.
+	cacheLockStaleAfter = 2 * time.Minute
+
+	cacheLockTimeout  = 30 * time.Second
+	cacheLockPollTime = 20 * time.Millisecond
+)
+
+// cacheLockOwner is written into each lock file so other builds can tell
+// whether the holder is still alive.
+type cacheLockOwner struct {
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// lockKey takes the lock for one cache entry, so builds sharing a cache
+// directory only wait for each other when they write the same key. Locks
+// are O_EXCL files next to the entry, which also works on shared volumes
+// where flock doesn't. The returned function releases the lock.
+func (c *Cache) lockKey(key string) (func(), error) {
+	path := c.getEntryPath(key) + ".lock"
+	host, _ := os.Hostname()
+	owner, _ := json.Marshal(cacheLockOwner{PID: os.Getpid(), Host: host, Acquired: time.Now()})
+
+	start := time.Now()
+	waited := false
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.Write(owner)
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write cache lock: %v", err)
+			}
+
+			if waited {
+				c.lockWaits++
+				c.lockWaitTime += time.Since(start)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create cache lock: %v", err)
+		}
+
+		if c.stealStaleLock(path, host) {
+			c.lockSteals++
+			continue
+		}
+
+		if time.Since(start) > cacheLockTimeout {
+			c.lockWaits++
+			c.lockWaitTime += time.Since(start)
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", path)
+		}
+
+		waited = true
+		time.Sleep(cacheLockPollTime)
+	}
+}
+
+// stealStaleLock removes the lock at path if its holder is gone. The lock
+// is renamed aside first and only deleted if it is still the one judged
+// stale, so two builds stealing at once can't remove a fresh lock.
+func (c *Cache) stealStaleLock(path, host string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !lockIsStale(data, info.ModTime(), host) {
+		return false
+	}
+
+	aside := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return false
+	}
+
+	current, err := os.ReadFile(aside)
+	if err != nil || string(current) != string(data) {
+		// Someone else stole it first and we grabbed their new lock;
+		// put it back. If another lock has appeared in the meantime the
+		// aside copy is kept rather than losing the holder's lock, and
+		// the caller retries; cache prune removes it once it is stale.
+		if os.Link(aside, path) == nil {
+			os.Remove(aside)
+		}
+		return false
+	}
+
+	os.Remove(aside)
+	return true
+}
+
+// lockIsStale reports whether a lock's holder has crashed: its process no
+// longer exists on this host, or the lock is older than any write takes.
+// A lock whose owner can't be read yet is still being written.
+func lockIsStale(data []byte, modTime time.Time, host string) bool {
+	if time.Since(modTime) > cacheLockStaleAfter {
+		return true
+	}
+
+	var owner cacheLockOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return false
+	}
+
+	if owner.Host == host && owner.PID > 0 {
+		if err := syscall.Kill(owner.PID, 0); err == syscall.ESRCH {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bibin-skaria/ossb/executors"
+)
+
+var (
+	activeBuildsMu sync.Mutex
+	activeBuilds   = make(map[string]*Builder)
+)
+
+// Cancel stops the running build with the given ID in this process, for
+// programs that embed the engine and run builds on someone else's behalf.
+// See CancelRunning for builds in other processes.
+func Cancel(buildID string) error {
+	activeBuildsMu.Lock()
+	builder, exists := activeBuilds[buildID]
+	activeBuildsMu.Unlock()
+
+	if !exists {
+		return fmt.Errorf("build %s is not running", buildID)
+	}
+
+	builder.Cancel()
+	return nil
+}
+
+// runningBuild is recorded under <cache>/running/<build-id>.json while a
+// build runs, so another process sharing the cache can find and cancel it.
+type runningBuild struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+// CancelRunning cancels a build started by any process sharing cacheDir on
+// this host, which is how `ossb builds cancel` reaches a build running in
+// another `ossb build`. Builds in this process are cancelled directly;
+// others are sent SIGTERM, which `ossb build` handles by cancelling the
+// build just like Ctrl-C.
+func CancelRunning(cacheDir, buildID string) error {
+	if err := Cancel(buildID); err == nil {
+		return nil
+	}
+
+	path := runningBuildPath(cacheDir, buildID)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("build %s is not running", buildID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read running build %s: %v", buildID, err)
+	}
+
+	var running runningBuild
+	if err := json.Unmarshal(data, &running); err != nil || running.PID <= 0 {
+		return fmt.Errorf("invalid running build record %s", path)
+	}
+	if host, _ := os.Hostname(); running.Host != host {
+		return fmt.Errorf("build %s is running on host %s; cancel it there", buildID, running.Host)
+	}
+
+	if err := syscall.Kill(running.PID, syscall.SIGTERM); err != nil {
+		if err == syscall.ESRCH {
+			// The build's process died without cleaning up after itself.
+			os.Remove(path)
+			return fmt.Errorf("build %s is not running", buildID)
+		}
+		return fmt.Errorf("failed to signal build %s (pid %d): %v", buildID, running.PID, err)
+	}
+	return nil
+}
+
+func runningBuildPath(cacheDir, buildID string) string {
+	return filepath.Join(cacheDir, "running", filepath.Base(buildID)+".json")
+}
+
+// ActiveBuilds returns the IDs of builds currently running in this process.
+func ActiveBuilds() []string {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+
+	ids := make([]string, 0, len(activeBuilds))
+	for id := range activeBuilds {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// registerBuild claims the build's ID, failing rather than replacing a
+// running build that already holds it.
+func registerBuild(b *Builder) error {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+	if _, exists := activeBuilds[b.buildID]; exists {
+		return fmt.Errorf("build %s is already running", b.buildID)
+	}
+
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(runningBuild{PID: os.Getpid(), Host: host, Started: time.Now()})
+	path := runningBuildPath(b.config.CacheDir, b.buildID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to record running build: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to record running build: %v", err)
+	}
+
+	activeBuilds[b.buildID] = b
+	return nil
+}
+
+func unregisterBuild(b *Builder) {
+	activeBuildsMu.Lock()
+	defer activeBuildsMu.Unlock()
+	if activeBuilds[b.buildID] == b {
+		delete(activeBuilds, b.buildID)
+		os.Remove(runningBuildPath(b.config.CacheDir, b.buildID))
+	}
+}
+
+// Cancel kills the build's running executor processes and hooks and makes
+// Build stop before the next step. Build then reports the result as
+// cancelled rather than failed and releases the workspace straight away.
+func (b *Builder) Cancel() {
+	if b.cancelled.Swap(true) {
+		return
+	}
+
+	b.cancelHooks()
+
+	b.releaseMu.Lock()
+	defer b.releaseMu.Unlock()
+	if !b.released {
+		executors.CancelProcesses(b.workDir)
+	}
+}
+
+func (b *Builder) Cancelled() bool {
+	return b.cancelled.Load()
+}
+
+func (b *Builder) BuildID() string {
+	return b.buildID
+}
@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+type History struct {
+	baseDir string
+}
+
+func NewHistory(cacheDir string) *History {
+	return &History{
+		baseDir: filepath.Join(cacheDir, "history"),
+	}
+}
+
+func (h *History) Save(result *types.BuildResult) error {
+	if result.BuildID == "" {
+		return fmt.Errorf("build result has no build ID")
+	}
+
+	if err := os.MkdirAll(h.baseDir, 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal build result: %v", err)
+	}
+
+	if err := os.WriteFile(h.entryPath(result.BuildID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write build history: %v", err)
+	}
+
+	return nil
+}
+
+func (h *History) Load(buildID string) (*types.BuildResult, error) {
+	data, err := os.ReadFile(h.entryPath(buildID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("build %s not found in history", buildID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build %s: %v", buildID, err)
+	}
+
+	var result types.BuildResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse build %s: %v", buildID, err)
+	}
+
+	return &result, nil
+}
+
+// List returns all recorded builds, oldest first.
+func (h *History) List() ([]*types.BuildResult, error) {
+	entries, err := os.ReadDir(h.baseDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history directory: %v", err)
+	}
+
+	var results []*types.BuildResult
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		result, err := h.Load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].StartedAt < results[j].StartedAt
+	})
+
+	return results, nil
+}
+
+func (h *History) entryPath(buildID string) string {
+	return filepath.Join(h.baseDir, filepath.Base(buildID)+".json")
+}
+
+type StepComparison struct {
+	Platform     string `json:"platform"`
+	Summary      string `json:"summary"`
+	BeforeMillis int64  `json:"before_ms"`
+	AfterMillis  int64  `json:"after_ms"`
+	CacheBefore  bool   `json:"cache_before"`
+	CacheAfter   bool   `json:"cache_after"`
+	Added        bool   `json:"added,omitempty"`
+	Removed      bool   `json:"removed,omitempty"`
+	Regression   bool   `json:"regression,omitempty"`
+}
+
+type PlatformComparison struct {
+	Platform     string `json:"platform"`
+	SizeBefore   int64  `json:"size_before"`
+	SizeAfter    int64  `json:"size_after"`
+	DigestBefore string `json:"digest_before,omitempty"`
+	DigestAfter  string `json:"digest_after,omitempty"`
+}
+
+// DigestChanged reports whether the platform's manifest changed. It is
+// false when either build didn't record one.
+func (p *PlatformComparison) DigestChanged() bool {
+	return p.DigestBefore != "" && p.DigestAfter != "" && p.DigestBefore != p.DigestAfter
+}
+
+type BuildComparison struct {
+	Before         string                `json:"before"`
+	After          string                `json:"after"`
+	DurationBefore string                `json:"duration_before"`
+	DurationAfter  string                `json:"duration_after"`
+	CacheHitsDelta int                   `json:"cache_hits_delta"`
+	DigestBefore   string                `json:"digest_before,omitempty"`
+	DigestAfter    string                `json:"digest_after,omitempty"`
+	DigestChanged  bool                  `json:"digest_changed"`
+	Steps          []*StepComparison     `json:"steps"`
+	Platforms      []*PlatformComparison `json:"platforms"`
+}
+
+// CompareBuilds lines up the steps of two recorded builds by platform and
+// step description. A step counts as a regression when it got slower by
+// more than threshold (a fraction, e.g. 0.2 for 20%) or lost its cache hit.
+func CompareBuilds(before, after *types.BuildResult, threshold float64) *BuildComparison {
+	comparison := &BuildComparison{
+		Before:         before.BuildID,
+		After:          after.BuildID,
+		DurationBefore: before.Duration,
+		DurationAfter:  after.Duration,
+		CacheHitsDelta: after.CacheHits - before.CacheHits,
+		DigestBefore:   before.Digest,
+		DigestAfter:    after.Digest,
+		// Builds recorded without an exported image have no digest to
+		// compare, so they never count as changed.
+		DigestChanged: before.Digest != "" && after.Digest != "" && before.Digest != after.Digest,
+	}
+
+	beforeSteps := indexSteps(before.Steps)
+	afterSteps := indexSteps(after.Steps)
+
+	for _, key := range afterSteps.order {
+		afterStep := afterSteps.steps[key]
+		step := &StepComparison{
+			Platform:    afterStep.Platform,
+			Summary:     afterStep.Summary,
+			AfterMillis: afterStep.DurationMillis,
+			CacheAfter:  afterStep.CacheHit,
+		}
+
+		if beforeStep, exists := beforeSteps.steps[key]; exists {
+			step.BeforeMillis = beforeStep.DurationMillis
+			step.CacheBefore = beforeStep.CacheHit
+			step.Regression = (beforeStep.CacheHit && !afterStep.CacheHit) ||
+				float64(afterStep.DurationMillis) > float64(beforeStep.DurationMillis)*(1+threshold)
+		} else {
+			step.Added = true
+		}
+
+		comparison.Steps = append(comparison.Steps, step)
+	}
+
+	for _, key := range beforeSteps.order {
+		if _, exists := afterSteps.steps[key]; exists {
+			continue
+		}
+		beforeStep := beforeSteps.steps[key]
+		comparison.Steps = append(comparison.Steps, &StepComparison{
+			Platform:     beforeStep.Platform,
+			Summary:      beforeStep.Summary,
+			BeforeMillis: beforeStep.DurationMillis,
+			CacheBefore:  beforeStep.CacheHit,
+			Removed:      true,
+		})
+	}
+
+	platforms := make(map[string]bool)
+	for platform := range before.PlatformResults {
+		platforms[platform] = true
+	}
+	for platform := range after.PlatformResults {
+		platforms[platform] = true
+	}
+
+	platformNames := make([]string, 0, len(platforms))
+	for platform := range platforms {
+		platformNames = append(platformNames, platform)
+	}
+	sort.Strings(platformNames)
+
+	for _, platform := range platformNames {
+		platformComparison := &PlatformComparison{Platform: platform}
+		if result, exists := before.PlatformResults[platform]; exists {
+			platformComparison.SizeBefore = result.Size
+			platformComparison.DigestBefore = result.ManifestID
+		}
+		if result, exists := after.PlatformResults[platform]; exists {
+			platformComparison.SizeAfter = result.Size
+			platformComparison.DigestAfter = result.ManifestID
+		}
+		comparison.Platforms = append(comparison.Platforms, platformComparison)
+	}
+
+	return comparison
+}
+
+// DurationDelta returns how much longer (or shorter, if negative) the
+// second build took.
+func (c *BuildComparison) DurationDelta() time.Duration {
+	before, _ := time.ParseDuration(c.DurationBefore)
+	after, _ := time.ParseDuration(c.DurationAfter)
+	return after - before
+}
+
+type indexedSteps struct {
+	order []string
+	steps map[string]*types.StepResult
+}
+
+// indexSteps keys steps by platform and description rather than position,
+// so inserting a Dockerfile instruction doesn't misalign everything after it.
+func indexSteps(steps []*types.StepResult) *indexedSteps {
+	indexed := &indexedSteps{steps: make(map[string]*types.StepResult)}
+	seen := make(map[string]int)
+
+	for _, step := range steps {
+		base := step.Platform + "|" + step.Summary
+		key := fmt.Sprintf("%s|%d", base, seen[base])
+		seen[base]++
+
+		indexed.order = append(indexed.order, key)
+		indexed.steps[key] = step
+	}
+
+	return indexed
+}
@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bibin-skaria/ossb/exporters"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// Lifecycle events hooks can be attached to.
+const (
+	HookPreBuild  = "pre-build"
+	HookPostStep  = "post-step"
+	HookOnFailure = "on-failure"
+	HookPostPush  = "post-push"
+)
+
+// What a failing hook does to the build: "warn" (the default) records a
+// build warning, "fail" fails the build and "ignore" does nothing.
+const (
+	HookFailureWarn   = "warn"
+	HookFailureFail   = "fail"
+	HookFailureIgnore = "ignore"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+var hookEvents = []string{HookPreBuild, HookPostStep, HookOnFailure, HookPostPush}
+
+// validateHooks checks hook definitions up front so a typo in the config
+// fails the build before it starts rather than when the hook is due.
+func validateHooks(hooks map[string][]types.Hook) error {
+	for event, list := range hooks {
+		known := false
+		for _, e := range hookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fmt.Errorf("unknown hook event %q (expected one of %s)", event, strings.Join(hookEvents, ", "))
+		}
+
+		for i, hook := range list {
+			if (len(hook.Command) == 0) == (hook.URL == "") {
+				return fmt.Errorf("%s hook %d must set exactly one of command and url", event, i+1)
+			}
+			if hook.Timeout != "" {
+				if timeout, err := time.ParseDuration(hook.Timeout); err != nil || timeout <= 0 {
+					return fmt.Errorf("%s hook %d has an invalid timeout %q", event, i+1, hook.Timeout)
+				}
+			}
+			switch hook.OnFailure {
+			case "", HookFailureWarn, HookFailureFail, HookFailureIgnore:
+			default:
+				return fmt.Errorf("%s hook %d has an invalid on_failure %q (expected warn, fail or ignore)", event, i+1, hook.OnFailure)
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks runs the hooks for event in order, passing build details plus
+// vars as OSSB_* environment variables (or as JSON to webhooks). It only
+// returns an error for a failing hook whose policy is "fail". Once the build
+// is cancelled the remaining hooks are skipped, and a running one is killed
+// without being reported.
+func (b *Builder) runHooks(result *types.BuildResult, event string, vars map[string]string) error {
+	hooks := b.config.Hooks[event]
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	env := map[string]string{
+		"OSSB_HOOK_EVENT": event,
+		"OSSB_BUILD_ID":   b.buildID,
+		"OSSB_CONTEXT":    b.config.Context,
+		"OSSB_DOCKERFILE": b.config.Dockerfile,
+		"OSSB_TAGS":       strings.Join(b.config.Tags, ","),
+		"OSSB_OUTPUT":     b.config.Output,
+		"OSSB_REGISTRY":   b.config.Registry,
+		"OSSB_PUSH":       fmt.Sprintf("%t", b.config.Push),
+	}
+	var platforms []string
+	for _, platform := range b.config.Platforms {
+		platforms = append(platforms, platform.String())
+	}
+	env["OSSB_PLATFORMS"] = strings.Join(platforms, ",")
+	for key, value := range vars {
+		env[key] = value
+	}
+
+	for _, hook := range hooks {
+		if b.Cancelled() {
+			return nil
+		}
+
+		output, err := runHook(b.hookCtx, hook, env)
+		if output != "" && b.config.Progress && b.progressOut != nil {
+			fmt.Fprintf(b.progressOut, "%s", output)
+		}
+		if err == nil || b.Cancelled() {
+			continue
+		}
+
+		err = fmt.Errorf("%s hook %s failed: %v", event, describeHook(hook), err)
+		switch hook.OnFailure {
+		case HookFailureIgnore:
+		case HookFailureFail:
+			return err
+		default:
+			result.Warnings = append(result.Warnings, err.Error())
+			if b.config.Progress && b.progressOut != nil {
+				fmt.Fprintf(b.progressOut, "Warning: %v\n", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runHook runs one hook, stopping it when it times out or when parent is
+// cancelled along with the build.
+func runHook(parent context.Context, hook types.Hook, env map[string]string) (string, error) {
+	timeout := defaultHookTimeout
+	if hook.Timeout != "" {
+		timeout, _ = time.ParseDuration(hook.Timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var err error
+	var output string
+	if hook.URL != "" {
+		err = postWebhook(ctx, hook.URL, env)
+	} else {
+		output, err = runHookCommand(ctx, hook.Command, env)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("timed out after %s", timeout)
+	}
+	return output, err
+}
+
+func runHookCommand(ctx context.Context, command []string, env map[string]string) (string, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	// Don't wait forever on children that inherited the output pipe.
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if trimmed := strings.TrimSpace(string(output)); trimmed != "" {
+			return "", fmt.Errorf("%v: %s", err, trimmed)
+		}
+		return "", err
+	}
+	return string(output), nil
+}
+
+// postWebhook sends the hook's variables as a JSON object, keyed the same
+// way as the environment variables commands get.
+func postWebhook(ctx context.Context, url string, env map[string]string) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func describeHook(hook types.Hook) string {
+	if hook.URL != "" {
+		return hook.URL
+	}
+	return fmt.Sprintf("%q", strings.Join(hook.Command, " "))
+}
+
+func stepHookVars(step *types.StepResult, success bool) map[string]string {
+	return map[string]string{
+		"OSSB_STEP_INDEX":       fmt.Sprintf("%d", step.Index),
+		"OSSB_STEP_PLATFORM":    step.Platform,
+		"OSSB_STEP_TYPE":        string(step.Type),
+		"OSSB_STEP_SUMMARY":     step.Summary,
+		"OSSB_STEP_CACHE_KEY":   step.CacheKey,
+		"OSSB_STEP_DURATION_MS": fmt.Sprintf("%d", step.DurationMillis),
+		"OSSB_STEP_SUCCESS":     fmt.Sprintf("%t", success),
+	}
+}
+
+func pushHookVars(config *types.BuildConfig, result *types.BuildResult) map[string]string {
+	var refs []string
+	for _, tag := range config.Tags {
+		refs = append(refs, exporters.PushReference(tag, config.Registry))
+	}
+
+	return map[string]string{
+		"OSSB_PUSHED_REFS":      strings.Join(refs, ","),
+		"OSSB_IMAGE_ID":         result.ImageID,
+		"OSSB_MANIFEST_LIST_ID": result.ManifestListID,
+	}
+}
@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// ExecutorPolicyError is returned when a build would need an executor the
+// operator's config doesn't allow. Builds never fall back to a different
+// executor, since that could quietly weaken isolation.
+type ExecutorPolicyError struct {
+	Executor string
+	Allowed  []string
+}
+
+func (e *ExecutorPolicyError) Error() string {
+	return fmt.Sprintf("executor policy: %q is not allowed in this environment (allowed: %s)", e.Executor, strings.Join(e.Allowed, ", "))
+}
+
+// selectExecutor returns the executor a build will use: the one requested
+// explicitly, or else the one implied by rootless mode and the target
+// platforms. The choice is then checked against the allowlist.
+func selectExecutor(config *types.BuildConfig) (string, error) {
+	executorType := config.Executor
+	if executorType == "" {
+		executorType = "local"
+		if config.Rootless {
+			executorType = "rootless"
+		} else if len(config.Platforms) > 1 || (len(config.Platforms) == 1 && config.Platforms[0].String() != types.GetHostPlatform().String()) {
+			executorType = "container"
+		}
+	}
+
+	if len(config.AllowedExecutors) == 0 {
+		return executorType, nil
+	}
+
+	for _, allowed := range config.AllowedExecutors {
+		if allowed == executorType {
+			return executorType, nil
+		}
+	}
+
+	return "", &ExecutorPolicyError{Executor: executorType, Allowed: config.AllowedExecutors}
+}
@@ -0,0 +1,427 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	quotaMethodXFS  = "xfs"
+	quotaMethodLoop = "loop"
+	quotaMethodWalk = "walk"
+
+	// mkfs.ext4 needs some room for its own metadata.
+	minLoopQuota = 16 * 1024 * 1024
+
+	// How often the walk fallback measures usage while a step runs.
+	quotaPollInterval = 2 * time.Second
+
+	// XFS project IDs are taken from [base, base+range), above the low
+	// IDs administrators usually assign by hand.
+	xfsProjectIDBase     = 100000
+	xfsProjectIDRange    = 1000000
+	xfsProjectIDAttempts = 1000
+)
+
+type QuotaExceededError struct {
+	Limit int64
+	Used  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Used > 0 {
+		return fmt.Sprintf("disk quota exceeded: build scratch space uses %s of its %s limit", formatSize(e.Used), formatSize(e.Limit))
+	}
+	return fmt.Sprintf("disk quota exceeded: build scratch space is limited to %s", formatSize(e.Limit))
+}
+
+// DiskQuota caps the scratch space of a single build's work directory. It
+// prefers an XFS project quota, falls back to mounting a loopback ext4
+// image over the directory, and otherwise measures usage while every step
+// runs.
+type DiskQuota struct {
+	dir          string
+	limit        int64
+	method       string
+	projectID    uint32
+	projectClaim string
+	mountPath    string
+	imagePath    string
+	warning      string
+}
+
+func NewDiskQuota(dir string, limit int64) (*DiskQuota, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("disk limit must be positive")
+	}
+
+	q := &DiskQuota{
+		dir:   dir,
+		limit: limit,
+	}
+
+	if err := q.setupXFS(); err == nil {
+		q.method = quotaMethodXFS
+		return q, nil
+	}
+
+	err := q.setupLoop()
+	if err == nil {
+		q.method = quotaMethodLoop
+		return q, nil
+	}
+
+	q.method = quotaMethodWalk
+	q.warning = fmt.Sprintf("disk limit is not kernel-enforced (%v); measuring usage every %s instead, so a step can briefly exceed it", err, quotaPollInterval)
+	return q, nil
+}
+
+func (q *DiskQuota) Method() string {
+	return q.method
+}
+
+func (q *DiskQuota) Limit() int64 {
+	return q.limit
+}
+
+// Warning explains why the limit falls back to measuring usage, or is
+// empty when the kernel enforces it.
+func (q *DiskQuota) Warning() string {
+	return q.warning
+}
+
+// Watch measures usage while a step runs when the kernel doesn't enforce
+// the limit, calling exceeded once if the directory grows past it. The
+// returned function stops watching and reports the QuotaExceededError, if
+// any.
+func (q *DiskQuota) Watch(exceeded func()) func() error {
+	if q.method != quotaMethodWalk {
+		return func() error { return nil }
+	}
+
+	var (
+		mu       sync.Mutex
+		quotaErr error
+	)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(quotaPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if err, ok := q.Check().(*QuotaExceededError); ok {
+				mu.Lock()
+				quotaErr = err
+				mu.Unlock()
+				exceeded()
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-stopped
+		mu.Lock()
+		defer mu.Unlock()
+		return quotaErr
+	}
+}
+
+// Check reports a QuotaExceededError if the directory has grown past the
+// limit. Kernel-enforced quotas make writes fail before this triggers, but
+// the walk keeps the limit meaningful when neither could be set up.
+func (q *DiskQuota) Check() error {
+	used, err := dirUsage(q.dir)
+	if err != nil {
+		return fmt.Errorf("failed to measure disk usage: %v", err)
+	}
+
+	if used > q.limit {
+		return &QuotaExceededError{Limit: q.limit, Used: used}
+	}
+	return nil
+}
+
+// Explain turns a failed step's error into a QuotaExceededError when it
+// failed because the kernel quota or loop filesystem ran out of space.
+func (q *DiskQuota) Explain(stepError string) error {
+	lower := strings.ToLower(stepError)
+	if strings.Contains(lower, "no space left on device") || strings.Contains(lower, "disk quota exceeded") {
+		used, _ := dirUsage(q.dir)
+		return &QuotaExceededError{Limit: q.limit, Used: used}
+	}
+	return nil
+}
+
+func (q *DiskQuota) Release() error {
+	switch q.method {
+	case quotaMethodXFS:
+		id := strconv.FormatUint(uint64(q.projectID), 10)
+		cmd := exec.Command("xfs_quota", "-x", "-c", "limit -p bhard=0 "+id, q.mountPath)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove xfs project quota: %v, output: %s", err, string(output))
+		}
+		q.releaseProjectID()
+	case quotaMethodLoop:
+		if output, err := exec.Command("umount", q.dir).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to unmount quota image: %v, output: %s", err, string(output))
+		}
+		if err := os.Remove(q.imagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove quota image: %v", err)
+		}
+	}
+	return nil
+}
+
+func (q *DiskQuota) setupXFS() error {
+	output, err := exec.Command("stat", "-f", "-c", "%T", q.dir).Output()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(output)) != "xfs" {
+		return fmt.Errorf("%s is not on xfs", q.dir)
+	}
+
+	output, err = exec.Command("df", "--output=target", q.dir).Output()
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	q.mountPath = strings.TrimSpace(lines[len(lines)-1])
+
+	if err := q.claimProjectID(); err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(q.projectID), 10)
+
+	commands := []string{
+		fmt.Sprintf("project -s -p %s %s", q.dir, id),
+		fmt.Sprintf("limit -p bhard=%d %s", q.limit, id),
+	}
+	for _, command := range commands {
+		cmd := exec.Command("xfs_quota", "-x", "-c", command, q.mountPath)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			q.releaseProjectID()
+			return fmt.Errorf("xfs_quota failed: %v, output: %s", err, string(output))
+		}
+	}
+
+	return nil
+}
+
+// claimProjectID picks an XFS project ID no other build or tool is using.
+// Project IDs are global to the filesystem, so builds claim them with an
+// O_EXCL file under <mount>/.ossb-projects, which every build on the
+// filesystem sees whatever its cache directory, and skip IDs the quota
+// report shows with usage or limits, which other tools may have set up.
+// The search starts at an ID derived from the work directory to keep
+// concurrent builds from contending for the same one.
+func (q *DiskQuota) claimProjectID() error {
+	claimsDir := filepath.Join(q.mountPath, ".ossb-projects")
+	if err := os.MkdirAll(claimsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create project ID claims: %v", err)
+	}
+
+	used, err := xfsProjectsInUse(q.mountPath)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	owner, _ := json.Marshal(cacheLockOwner{PID: os.Getpid(), Host: host, Acquired: time.Now()})
+
+	start := crc32.ChecksumIEEE([]byte(q.dir)) % xfsProjectIDRange
+	for i := uint32(0); i < xfsProjectIDAttempts; i++ {
+		id := xfsProjectIDBase + (start+i)%xfsProjectIDRange
+		if used[id] {
+			continue
+		}
+
+		path := filepath.Join(claimsDir, strconv.FormatUint(uint64(id), 10))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) && releaseDeadClaim(path, host) {
+			file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		}
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim project ID: %v", err)
+		}
+
+		_, err = file.Write(owner)
+		file.Close()
+		if err != nil {
+			os.Remove(path)
+			return fmt.Errorf("failed to claim project ID: %v", err)
+		}
+
+		q.projectID = id
+		q.projectClaim = path
+		return nil
+	}
+
+	return fmt.Errorf("no free xfs project ID after %d attempts", xfsProjectIDAttempts)
+}
+
+func (q *DiskQuota) releaseProjectID() {
+	if q.projectClaim != "" {
+		os.Remove(q.projectClaim)
+		q.projectClaim = ""
+	}
+}
+
+// releaseDeadClaim removes a project ID claim whose build crashed: its
+// process no longer exists on this host. Claims from other hosts sharing
+// the filesystem are left alone.
+func releaseDeadClaim(path, host string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var owner cacheLockOwner
+	if err := json.Unmarshal(data, &owner); err != nil || owner.Host != host || owner.PID <= 0 {
+		return false
+	}
+	if err := syscall.Kill(owner.PID, 0); err != syscall.ESRCH {
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+// xfsProjectsInUse returns the project IDs the filesystem's quota report
+// shows with any usage or limit.
+func xfsProjectsInUse(mountPath string) (map[uint32]bool, error) {
+	output, err := exec.Command("xfs_quota", "-x", "-c", "report -p -n -N -b", mountPath).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("xfs_quota report failed: %v, output: %s", err, string(output))
+	}
+
+	used := make(map[uint32]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "#"), 10, 32)
+		if err != nil {
+			continue
+		}
+		// Blocks used, soft and hard limits.
+		for _, value := range fields[1:4] {
+			if value != "0" {
+				used[uint32(id)] = true
+				break
+			}
+		}
+	}
+	return used, nil
+}
+
+func (q *DiskQuota) setupLoop() error {
+	if q.limit < minLoopQuota {
+		return fmt.Errorf("limit too small for a loopback filesystem")
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("mounting a loopback filesystem needs root")
+	}
+
+	q.imagePath = filepath.Clean(q.dir) + ".img"
+
+	commands := [][]string{
+		{"truncate", "-s", strconv.FormatInt(q.limit, 10), q.imagePath},
+		{"mkfs.ext4", "-q", "-F", "-m", "0", q.imagePath},
+		{"mount", "-o", "loop", q.imagePath, q.dir},
+	}
+	for _, args := range commands {
+		if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			os.Remove(q.imagePath)
+			return fmt.Errorf("%s failed: %v, output: %s", args[0], err, string(output))
+		}
+	}
+
+	return nil
+}
+
+func dirUsage(dir string) (int64, error) {
+	var used int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			used += info.Size()
+		}
+		return nil
+	})
+	return used, err
+}
+
+// ParseSize parses sizes such as "512M", "10G" or "1073741824".
+func ParseSize(size string) (int64, error) {
+	value := strings.TrimSpace(strings.ToUpper(size))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "IB"), "B")
+
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	bytes := int64(number * float64(multiplier))
+	if bytes <= 0 {
+		return 0, fmt.Errorf("size %q must be at least one byte", size)
+	}
+	return bytes, nil
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
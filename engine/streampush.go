@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bibin-skaria/ossb/exporters"
+	"github.com/bibin-skaria/ossb/internal/registry"
+)
+
+// LayerStreamer uploads finished layers to the target registry in the
+// background while later steps are still running. The exporter packs
+// layers the same deterministic way, so by the time the image is pushed the
+// registry already has them and only the config and manifest remain.
+//
+// Only sealed layers are streamed: a layer directory is sealed once a
+// later one exists, since the step that follows may still be writing to
+// the newest. The newest layer is left to the final push.
+type LayerStreamer struct {
+	client   *registry.Client
+	blobsDir string
+	wake     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	pending []string
+	queued  map[string]bool
+	closed  bool
+
+	pushed   map[string]bool
+	uploaded int
+	bytes    int64
+	errors   []string
+	quota    *registry.QuotaError
+}
+
+// Uploads that fail for reasons other than the registry's quota are
+// retried, waiting streamRetryDelay, then twice that, between attempts.
+const (
+	streamAttempts   = 3
+	streamRetryDelay = time.Second
+)
+
+func NewLayerStreamer(ref, blobsDir string) (*LayerStreamer, error) {
+	client, _, err := registry.NewClient(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LayerStreamer{
+		client:   client,
+		blobsDir: blobsDir,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		queued:   make(map[string]bool),
+		pushed:   make(map[string]bool),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// Snapshot queues the layers under layersDir that have been sealed since
+// it last looked. It lists them straight away, while no step is running,
+// and never blocks on uploads.
+func (s *LayerStreamer) Snapshot(layersDir string) {
+	dirs, err := exporters.LayerDirs(layersDir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if err != nil {
+		s.errors = append(s.errors, fmt.Sprintf("failed to list layers: %v", err))
+		return
+	}
+
+	for i := 0; i < len(dirs)-1; i++ {
+		if !s.queued[dirs[i]] {
+			s.queued[dirs[i]] = true
+			s.pending = append(s.pending, dirs[i])
+		}
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Finish waits for pending uploads and reports how many blobs and bytes
+// were streamed, plus any upload errors. Errors are not fatal: the final
+// push uploads anything the registry is still missing.
+func (s *LayerStreamer) Finish() (int, int64, []string) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		close(s.wake)
+		s.mu.Unlock()
+
+		<-s.done
+		os.RemoveAll(s.blobsDir)
+	})
+	return s.uploaded, s.bytes, s.errors
+}
+
+// QuotaError returns the registry's quota rejection if an upload hit one.
+// Call it after Finish.
+func (s *LayerStreamer) QuotaError() *registry.QuotaError {
+	return s.quota
+}
+
+func (s *LayerStreamer) run() {
+	defer close(s.done)
+
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if len(s.pending) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			dir := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+
+			// Once the registry is out of storage every further upload
+			// would be rejected too; the final push reports the quota
+			// error.
+			if s.quota != nil {
+				continue
+			}
+
+			if err := s.upload(dir); err != nil {
+				s.mu.Lock()
+				s.errors = append(s.errors, err.Error())
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *LayerStreamer) upload(dir string) error {
+	blob, err := exporters.WriteLayerBlob(dir, s.blobsDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(blob.Path)
+
+	// Identical layers pack to the same blob; upload it once.
+	if s.pushed[blob.Descriptor.Digest] {
+		return nil
+	}
+
+	var uploaded bool
+	for attempt := 1; ; attempt++ {
+		uploaded, err = s.client.PushBlob(blob.Descriptor.Digest, blob.Path)
+		if err == nil {
+			break
+		}
+
+		var quota *registry.QuotaError
+		if errors.As(err, &quota) {
+			s.quota = quota
+		}
+		if s.quota != nil || attempt == streamAttempts {
+			return fmt.Errorf("failed to stream %s to %s: %v", blob.Descriptor.Digest, s.client.Repository(), err)
+		}
+		time.Sleep(streamRetryDelay << (attempt - 1))
+	}
+
+	s.pushed[blob.Descriptor.Digest] = true
+	if uploaded {
+		s.uploaded++
+		s.bytes += blob.Descriptor.Size
+	}
+
+	return nil
+}
@@ -0,0 +1,451 @@
+package engine
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+type TranscriptEntry struct {
+	Sequence  int      `json:"sequence"`
+	Platform  string   `json:"platform"`
+	Summary   string   `json:"summary"`
+	Command   []string `json:"command,omitempty"`
+	CacheKey  string   `json:"cache_key"`
+	CacheHit  bool     `json:"cache_hit"`
+	Success   bool     `json:"success"`
+	Error     string   `json:"error,omitempty"`
+	Log       string   `json:"log,omitempty"`
+	LogDigest string   `json:"log_digest"`
+	Recorded  string   `json:"recorded"`
+	PrevHash  string   `json:"prev_hash"`
+	Hash      string   `json:"hash"`
+}
+
+// TranscriptSummary is the signed part of a transcript. Digest and
+// PlatformDigests identify the exported image itself; ImageID may only
+// name a tag, which can later point anywhere.
+type TranscriptSummary struct {
+	BuildID         string            `json:"build_id"`
+	Success         bool              `json:"success"`
+	Error           string            `json:"error,omitempty"`
+	ImageID         string            `json:"image_id,omitempty"`
+	ManifestListID  string            `json:"manifest_list_id,omitempty"`
+	Digest          string            `json:"digest,omitempty"`
+	PlatformDigests map[string]string `json:"platform_digests,omitempty"`
+	Platforms       []string          `json:"platforms"`
+	Steps           int               `json:"steps"`
+	ChainHead       string            `json:"chain_head"`
+	Finished        string            `json:"finished"`
+}
+
+// Transcript is a tamper-evident record of a build: every step is chained
+// to the previous one by hash, and the summary (which includes the chain
+// head) is signed and optionally timestamped by an RFC 3161 authority.
+type Transcript struct {
+	Entries   []*TranscriptEntry `json:"entries"`
+	Summary   *TranscriptSummary `json:"summary,omitempty"`
+	Algorithm string             `json:"algorithm,omitempty"`
+	PublicKey string             `json:"public_key,omitempty"`
+	Signature string             `json:"signature,omitempty"`
+	Timestamp string             `json:"timestamp,omitempty"`
+
+	signer        crypto.Signer
+	signerTrusted bool
+}
+
+func NewTranscript(keyPath string) (*Transcript, error) {
+	t := &Transcript{}
+	if keyPath == "" {
+		return t, nil
+	}
+
+	signer, err := loadSigningKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	t.signer = signer
+	t.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	switch signer.(type) {
+	case ed25519.PrivateKey:
+		t.Algorithm = "ed25519"
+	case *ecdsa.PrivateKey:
+		t.Algorithm = "ecdsa-sha256"
+	case *rsa.PrivateKey:
+		t.Algorithm = "rsa-pkcs1v15-sha256"
+	}
+
+	return t, nil
+}
+
+func (t *Transcript) Record(step *types.StepResult, operation *types.Operation, opResult *types.OperationResult, stepErr error) {
+	entry := &TranscriptEntry{
+		Sequence: len(t.Entries),
+		Platform: step.Platform,
+		Summary:  step.Summary,
+		Command:  operation.Command,
+		CacheKey: step.CacheKey,
+		Recorded: time.Now().UTC().Format(time.RFC3339Nano),
+		PrevHash: t.head(),
+	}
+
+	if stepErr != nil {
+		entry.Error = stepErr.Error()
+	} else if opResult != nil {
+		entry.Success = opResult.Success
+		entry.CacheHit = opResult.CacheHit
+		entry.Error = opResult.Error
+		entry.Log = opResult.Log
+	}
+
+	entry.LogDigest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(entry.Log)))
+	entry.Hash = entry.computeHash()
+
+	t.Entries = append(t.Entries, entry)
+}
+
+// Finish seals the transcript with a summary of the build, signs it when a
+// key was configured and, if tsaURL is set, obtains an RFC 3161 timestamp
+// over the summary signature.
+func (t *Transcript) Finish(result *types.BuildResult, tsaURL string) error {
+	summary := &TranscriptSummary{
+		BuildID:        result.BuildID,
+		Success:        result.Success,
+		Error:          result.Error,
+		ImageID:        result.ImageID,
+		ManifestListID: result.ManifestListID,
+		Digest:         result.Digest,
+		Steps:          len(t.Entries),
+		ChainHead:      t.head(),
+		Finished:       time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for platform, platformResult := range result.PlatformResults {
+		summary.Platforms = append(summary.Platforms, platform)
+		if platformResult.ManifestID != "" {
+			if summary.PlatformDigests == nil {
+				summary.PlatformDigests = make(map[string]string)
+			}
+			summary.PlatformDigests[platform] = platformResult.ManifestID
+		}
+	}
+	sort.Strings(summary.Platforms)
+	t.Summary = summary
+
+	if t.signer == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript summary: %v", err)
+	}
+
+	signature, err := signPayload(t.signer, payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign transcript: %v", err)
+	}
+	t.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	if tsaURL != "" {
+		token, err := requestTimestamp(tsaURL, signature)
+		if err != nil {
+			return fmt.Errorf("failed to timestamp transcript: %v", err)
+		}
+		t.Timestamp = base64.StdEncoding.EncodeToString(token)
+	}
+
+	return nil
+}
+
+func (t *Transcript) WriteFile(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %v", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write transcript: %v", err)
+	}
+
+	return nil
+}
+
+// VerifyTranscript recomputes the hash chain and checks the summary
+// signature against the embedded public key. A valid signature only says
+// who signed the transcript if that key is one of trustedKeys (fingerprints
+// as returned by PublicKeyFingerprint): a key that isn't trusted fails
+// verification, and with no trusted keys the signer is left unverified
+// (see SignerTrusted). The RFC 3161 token, if any, is left to standard
+// tooling (e.g. openssl ts -verify).
+func VerifyTranscript(path string, trustedKeys []string) (*Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %v", err)
+	}
+
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse transcript: %v", err)
+	}
+
+	prev := ""
+	for i, entry := range t.Entries {
+		if entry.Sequence != i {
+			return nil, fmt.Errorf("entry %d: sequence is %d", i, entry.Sequence)
+		}
+		if entry.PrevHash != prev {
+			return nil, fmt.Errorf("entry %d: chain broken (prev_hash does not match entry %d)", i, i-1)
+		}
+		if fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(entry.Log))) != entry.LogDigest {
+			return nil, fmt.Errorf("entry %d: log digest mismatch, log was modified", i)
+		}
+		if entry.computeHash() != entry.Hash {
+			return nil, fmt.Errorf("entry %d: hash mismatch, entry was modified", i)
+		}
+		prev = entry.Hash
+	}
+
+	if t.Summary == nil {
+		return nil, fmt.Errorf("transcript has no summary")
+	}
+	if t.Summary.ChainHead != prev || t.Summary.Steps != len(t.Entries) {
+		return nil, fmt.Errorf("summary does not match the recorded entries")
+	}
+
+	if t.Signature == "" {
+		if len(trustedKeys) > 0 {
+			return nil, fmt.Errorf("transcript is not signed")
+		}
+		return &t, nil
+	}
+
+	publicKeyData, err := base64.StdEncoding.DecodeString(t.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %v", err)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(t.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	payload, err := json.Marshal(t.Summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transcript summary: %v", err)
+	}
+	digest := sha256.Sum256(payload)
+
+	valid := false
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, payload, signature)
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	if !valid {
+		return nil, fmt.Errorf("signature verification failed")
+	}
+
+	if len(trustedKeys) > 0 {
+		fingerprint := t.KeyFingerprint()
+		for _, trusted := range trustedKeys {
+			if trusted == fingerprint {
+				t.signerTrusted = true
+			}
+		}
+		if !t.signerTrusted {
+			return nil, fmt.Errorf("transcript was signed by an untrusted key %s", fingerprint)
+		}
+	}
+
+	return &t, nil
+}
+
+// SignerTrusted reports whether VerifyTranscript matched the signing key
+// against a trusted key.
+func (t *Transcript) SignerTrusted() bool {
+	return t.signerTrusted
+}
+
+// KeyFingerprint returns the fingerprint of the embedded public key, or ""
+// for an unsigned transcript.
+func (t *Transcript) KeyFingerprint() string {
+	publicKey, err := base64.StdEncoding.DecodeString(t.PublicKey)
+	if err != nil || len(publicKey) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(publicKey))
+}
+
+// PublicKeyFingerprint returns the fingerprint of a PEM public key, in the
+// form operators pin in the config file.
+func PublicKeyFingerprint(data []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return "", fmt.Errorf("no PEM public key found")
+	}
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(block.Bytes)), nil
+}
+
+func (t *Transcript) head() string {
+	if len(t.Entries) == 0 {
+		return ""
+	}
+	return t.Entries[len(t.Entries)-1].Hash
+}
+
+func (e *TranscriptEntry) computeHash() string {
+	unsigned := *e
+	unsigned.Hash = ""
+	data, _ := json.Marshal(unsigned)
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return signer, nil
+}
+
+func signPayload(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+type tsaMessageImprint struct {
+	HashAlgorithm struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue `asn1:"optional"`
+	}
+	HashedMessage []byte
+}
+
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsaResponse struct {
+	Status struct {
+		Status       int
+		StatusString []string       `asn1:"optional,utf8"`
+		FailInfo     asn1.BitString `asn1:"optional"`
+	}
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+var oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+// requestTimestamp asks an RFC 3161 time-stamping authority to timestamp
+// the given data and returns the DER-encoded TimeStampToken.
+func requestTimestamp(tsaURL string, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	req := tsaRequest{Version: 1, Nonce: nonce, CertReq: true}
+	req.MessageImprint.HashAlgorithm.Algorithm = oidSHA256
+	req.MessageImprint.HashAlgorithm.Parameters = asn1.NullRawValue
+	req.MessageImprint.HashedMessage = digest[:]
+
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode timestamp request: %v", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(tsaURL, "application/timestamp-query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority returned %s", resp.Status)
+	}
+
+	var tsResp tsaResponse
+	if _, err := asn1.Unmarshal(respBody, &tsResp); err != nil {
+		return nil, fmt.Errorf("failed to parse timestamp response: %v", err)
+	}
+
+	// 0 = granted, 1 = granted with modifications
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected (status %d): %v", tsResp.Status.Status, tsResp.Status.StatusString)
+	}
+	if len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp response contains no token")
+	}
+
+	return tsResp.TimeStampToken.FullBytes, nil
+}
@@ -2,7 +2,6 @@ package executors
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -16,7 +15,6 @@ import (
 
 type ContainerExecutor struct {
 	runtime         string
-	supportedQEMU   map[string]string
 	registryAuth    string
 }
 
@@ -28,19 +26,8 @@ func NewContainerExecutor(runtime string) *ContainerExecutor {
 		}
 	}
 
-	supportedQEMU := map[string]string{
-		"linux/amd64":   "",
-		"linux/arm64":   "qemu-aarch64-static",
-		"linux/arm/v7":  "qemu-arm-static", 
-		"linux/arm/v6":  "qemu-arm-static",
-		"linux/386":     "qemu-i386-static",
-		"linux/ppc64le": "qemu-ppc64le-static",
-		"linux/s390x":   "qemu-s390x-static",
-	}
-
 	return &ContainerExecutor{
-		runtime:       runtime,
-		supportedQEMU: supportedQEMU,
+		runtime: runtime,
 	}
 }
 
@@ -92,16 +79,35 @@ func (e *ContainerExecutor) executeSource(operation *types.Operation, workDir st
 		return result, nil
 	}
 
+	if isLocalSource(operation) {
+		if err := e.setupQEMU(platform); err != nil {
+			result.Error = fmt.Sprintf("failed to setup QEMU for %s: %v", platform.String(), err)
+			return result, nil
+		}
+		return seedSource(operation, workDir, filepath.Join(workDir, "base", platform.String()), platform, result)
+	}
+
 	platformFlag := fmt.Sprintf("--platform=%s", platform.String())
 	
 	cmd := exec.Command(e.runtime, "pull", platformFlag, image)
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(workDir, cmd)
 	if err != nil {
 		result.Error = fmt.Sprintf("failed to pull image %s for %s: %v, output: %s", 
 			image, platform.String(), err, string(output))
 		return result, nil
 	}
 
+	info, err := e.inspectImage(image)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to inspect image %s: %v", image, err)
+		return result, nil
+	}
+
+	if err := checkPulledPlatform(image, info, platform); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
 	if err := e.setupQEMU(platform); err != nil {
 		result.Error = fmt.Sprintf("failed to setup QEMU for %s: %v", platform.String(), err)
 		return result, nil
@@ -114,7 +120,9 @@ func (e *ContainerExecutor) executeSource(operation *types.Operation, workDir st
 	}
 
 	containerName := fmt.Sprintf("ossb-extract-%d", time.Now().UnixNano())
-	createCmd := exec.Command(e.runtime, "create", platformFlag, "--name", containerName, image)
+	// Create from the verified image ID rather than the tag, which a
+	// concurrent pull for another platform may have repointed.
+	createCmd := exec.Command(e.runtime, "create", platformFlag, "--name", containerName, info.ID)
 	if output, err := createCmd.CombinedOutput(); err != nil {
 		result.Error = fmt.Sprintf("failed to create container: %v, output: %s", err, string(output))
 		return result, nil
@@ -133,22 +141,22 @@ func (e *ContainerExecutor) executeSource(operation *types.Operation, workDir st
 	tarCmd.Stdin = pipeReader
 	tarCmd.Stderr = os.Stderr
 
-	if err := exportCmd.Start(); err != nil {
+	if err := startCommand(workDir, exportCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to start export: %v", err)
 		return result, nil
 	}
 
-	if err := tarCmd.Start(); err != nil {
+	if err := startCommand(workDir, tarCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to start tar extraction: %v", err)
 		return result, nil
 	}
 
-	if err := exportCmd.Wait(); err != nil {
+	if err := waitCommand(workDir, exportCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to export container: %v", err)
 		return result, nil
 	}
 
-	if err := tarCmd.Wait(); err != nil {
+	if err := waitCommand(workDir, tarCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to extract tar: %v", err)
 		return result, nil
 	}
@@ -205,26 +213,35 @@ WORKDIR %s
 		envFlags = append(envFlags, "-e", fmt.Sprintf("%s=%s", key, value))
 	}
 
+	// The container belongs to the runtime daemon, not to our process group,
+	// so cancelling the build has to remove it by name.
+	containerName := fmt.Sprintf("ossb-run-%d", time.Now().UnixNano())
+	release := onCancel(workDir, containerName, func() {
+		exec.Command(e.runtime, "rm", "-f", containerName).Run()
+	})
+	defer release()
+
 	var cmd *exec.Cmd
 	if len(operation.Command) == 1 {
 		cmd = exec.Command(e.runtime, append([]string{
-			"run", "--rm", platformFlag,
+			"run", "--rm", "--name", containerName, platformFlag,
 			"-v", fmt.Sprintf("%s:/workspace", baseDir),
 			"-w", operation.WorkDir,
 		}, append(envFlags, "busybox:latest", "sh", "-c", operation.Command[0])...)...)
 	} else {
 		cmd = exec.Command(e.runtime, append([]string{
-			"run", "--rm", platformFlag,
+			"run", "--rm", "--name", containerName, platformFlag,
 			"-v", fmt.Sprintf("%s:/workspace", baseDir),
 			"-w", operation.WorkDir,
 		}, append(envFlags, append([]string{"busybox:latest"}, operation.Command...)...)...)...)
 	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(workDir, cmd)
 	if err != nil {
 		result.Error = fmt.Sprintf("command failed: %v, output: %s", err, string(output))
 		return result, nil
 	}
+	result.Log = string(output)
 
 	if err := e.captureLayerChanges(baseDir, layerDir); err != nil {
 		result.Error = fmt.Sprintf("failed to capture layer changes: %v", err)
@@ -271,14 +288,30 @@ func (e *ContainerExecutor) executeFile(operation *types.Operation, workDir stri
 
 	sources := operation.Inputs[1:] 
 
+	matcher, contextDir, err := contextMatcher(operation)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid .dockerignore: %v", err)
+		return result, nil
+	}
+
 	switch operationType {
 	case "copy":
-		if err := e.copyFiles(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.copyFiles(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("copy failed: %v", err)
 			return result, nil
 		}
 	case "add":
-		if err := e.addFiles(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.addFiles(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("add failed: %v", err)
 			return result, nil
 		}
@@ -300,6 +333,12 @@ func (e *ContainerExecutor) executeFile(operation *types.Operation, workDir stri
 }
 
 func (e *ContainerExecutor) executeMeta(operation *types.Operation, workDir string, result *types.OperationResult) (*types.OperationResult, error) {
+	platform := operation.Platform
+	if platform.OS == "" {
+		platform = types.GetHostPlatform()
+	}
+	recordResolvedUser(operation, filepath.Join(workDir, "base", platform.String()), result)
+
 	result.Success = true
 	result.Outputs = operation.Outputs
 	result.Environment = operation.Environment
@@ -313,11 +352,13 @@ func (e *ContainerExecutor) setupQEMU(platform types.Platform) error {
 		return nil
 	}
 
-	qemuBinary, exists := e.supportedQEMU[platform.String()]
-	if !exists || qemuBinary == "" {
+	arch := qemuArch(platform)
+	if arch == "" {
 		return nil
 	}
 
+	qemuBinary := fmt.Sprintf("qemu-%s-static", arch)
+
 	if _, err := exec.LookPath(qemuBinary); err != nil {
 		cmd := exec.Command(e.runtime, "run", "--privileged", "--rm",
 			"tonistiigi/binfmt:qemu-v8", "--install", platform.Architecture)
@@ -379,9 +420,8 @@ func (e *ContainerExecutor) copyDir(source, dest string) error {
 	return nil
 }
 
-func (e *ContainerExecutor) inspectImage(image string, platform types.Platform) (map[string]interface{}, error) {
-	platformFlag := fmt.Sprintf("--platform=%s", platform.String())
-	cmd := exec.Command(e.runtime, "inspect", platformFlag, image)
+func (e *ContainerExecutor) inspectImage(image string) (*imageInspect, error) {
+	cmd := exec.Command(e.runtime, "image", "inspect", image)
 	
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
@@ -390,14 +430,5 @@ func (e *ContainerExecutor) inspectImage(image string, platform types.Platform)
 		return nil, fmt.Errorf("failed to inspect image: %v", err)
 	}
 	
-	var inspectData []map[string]interface{}
-	if err := json.Unmarshal(stdout.Bytes(), &inspectData); err != nil {
-		return nil, fmt.Errorf("failed to parse inspect output: %v", err)
-	}
-	
-	if len(inspectData) == 0 {
-		return nil, fmt.Errorf("no inspect data returned")
-	}
-	
-	return inspectData[0], nil
+	return parseImageInspect(stdout.Bytes())
 }
\ No newline at end of file
@@ -0,0 +1,192 @@
+package executors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bibin-skaria/ossb/internal/dockerignore"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// contextMatcher returns the build context of a COPY or ADD step that copies
+// from one, with a matcher for the .dockerignore patterns the frontend
+// attached (which matches nothing if there are none). It returns nil for
+// other steps.
+func contextMatcher(operation *types.Operation) (*dockerignore.Matcher, string, error) {
+	if operation.Context == "" {
+		return nil, "", nil
+	}
+
+	var patterns []string
+	if ignore := operation.Metadata["dockerignore"]; ignore != "" {
+		patterns = strings.Split(ignore, "\n")
+	}
+
+	matcher, err := dockerignore.New(patterns)
+	if err != nil {
+		return nil, "", err
+	}
+	return matcher, operation.Context, nil
+}
+
+// copyFromContext copies sources from the build context into dest, leaving
+// out everything the ignore patterns exclude, as if those files had never
+// been in the context. Like Docker, a file lands inside dest rather than
+// replacing it when dest ends in "/" (destIsDir), is an existing directory
+// or receives more than one source.
+func copyFromContext(sources []string, dest string, destIsDir bool, contextDir string, matcher *dockerignore.Matcher) error {
+	for _, source := range sources {
+		rel, err := filepath.Rel(contextDir, source)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("source %s is outside the build context", source)
+		}
+
+		info, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("source does not exist: %s", source)
+		}
+
+		if !info.IsDir() {
+			if matcher.Excluded(rel) {
+				return fmt.Errorf("source %s is excluded by .dockerignore", rel)
+			}
+			target := dest
+			if destIsDir || len(sources) > 1 || isDir(dest) {
+				target = filepath.Join(dest, filepath.Base(source))
+			}
+			if err := copyContextFile(source, target, info); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := copyContextDir(source, dest, contextDir, matcher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyContextDir(source, dest, contextDir string, matcher *dockerignore.Matcher) error {
+	// Directory times are set once everything is copied, since adding
+	// entries to a directory updates its modification time.
+	type copiedDir struct {
+		target string
+		info   os.FileInfo
+	}
+	var dirs []copiedDir
+
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		contextRel, _ := filepath.Rel(contextDir, path)
+		if path != source && matcher.Excluded(contextRel) {
+			// A "!" pattern may re-include something further down, so only
+			// prune the directory when there are none.
+			if info.IsDir() && !matcher.HasExclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, _ := filepath.Rel(source, path)
+		target := filepath.Join(dest, rel)
+
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, copiedDir{target: target, info: info})
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return preserveMetadata(target, info)
+		default:
+			return copyContextFile(path, target, info)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := preserveMetadata(dirs[i].target, dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func copyContextFile(source, dest string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return preserveMetadata(dest, info)
+}
+
+// preserveMetadata gives a copy its source's owner, mode bits and access
+// and modification times, as cp -a does. Ownership is only kept when the
+// builder may change it (as root); otherwise the copy stays the builder's.
+// Symlinks keep their owner but not their own times.
+func preserveMetadata(target string, info os.FileInfo) error {
+	stat, hasStat := info.Sys().(*syscall.Stat_t)
+	if hasStat {
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil && !os.IsPermission(err) {
+			return err
+		}
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	// Changing the owner clears setuid and setgid, and the mode given when
+	// creating the copy was masked by the umask.
+	if err := os.Chmod(target, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	atime := info.ModTime()
+	if hasStat {
+		atime = time.Unix(stat.Atim.Unix())
+	}
+	return os.Chtimes(target, atime, info.ModTime())
+}
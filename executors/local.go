@@ -53,6 +53,10 @@ func (e *LocalExecutor) executeSource(operation *types.Operation, workDir string
 		return result, nil
 	}
 
+	if isLocalSource(operation) {
+		return seedSource(operation, workDir, filepath.Join(workDir, "base"), types.GetHostPlatform(), result)
+	}
+
 	baseDir := filepath.Join(workDir, "base")
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		result.Error = fmt.Sprintf("failed to create base directory: %v", err)
@@ -101,7 +105,8 @@ func (e *LocalExecutor) executeExec(operation *types.Operation, workDir string,
 	cmd.Env = e.buildEnvironment(operation.Environment)
 
 	if operation.User != "" && operation.User != "root" {
-		uid, gid, err := e.parseUser(operation.User)
+		spec, _ := resolveUser(filepath.Join(workDir, "base"), operation.User)
+		uid, gid, err := e.parseUser(spec)
 		if err != nil {
 			result.Error = fmt.Sprintf("failed to parse user: %v", err)
 			return result, nil
@@ -114,13 +119,14 @@ func (e *LocalExecutor) executeExec(operation *types.Operation, workDir string,
 		}
 	}
 
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(workDir, cmd)
 	if err != nil {
 		result.Error = fmt.Sprintf("command failed: %v, output: %s", err, string(output))
 		return result, nil
 	}
 
 	result.Success = true
+	result.Log = string(output)
 	result.Outputs = operation.Outputs
 	result.Environment = operation.Environment
 	
@@ -153,15 +159,31 @@ func (e *LocalExecutor) executeFile(operation *types.Operation, workDir string,
 	}
 
 	sources := operation.Inputs[1:] 
+
+	matcher, contextDir, err := contextMatcher(operation)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid .dockerignore: %v", err)
+		return result, nil
+	}
 	
 	switch operationType {
 	case "copy":
-		if err := e.copyFiles(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.copyFiles(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("copy failed: %v", err)
 			return result, nil
 		}
 	case "add":
-		if err := e.addFiles(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.addFiles(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("add failed: %v", err)
 			return result, nil
 		}
@@ -178,6 +200,8 @@ func (e *LocalExecutor) executeFile(operation *types.Operation, workDir string,
 }
 
 func (e *LocalExecutor) executeMeta(operation *types.Operation, workDir string, result *types.OperationResult) (*types.OperationResult, error) {
+	recordResolvedUser(operation, filepath.Join(workDir, "base"), result)
+
 	result.Success = true
 	result.Outputs = operation.Outputs
 	result.Environment = operation.Environment
@@ -0,0 +1,66 @@
+package executors
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+type imageInspect struct {
+	ID           string `json:"Id"`
+	OS           string `json:"Os"`
+	Architecture string `json:"Architecture"`
+	Variant      string `json:"Variant"`
+}
+
+func parseImageInspect(output []byte) (*imageInspect, error) {
+	var inspectData []imageInspect
+	if err := json.Unmarshal(output, &inspectData); err != nil {
+		return nil, fmt.Errorf("failed to parse inspect output: %v", err)
+	}
+
+	if len(inspectData) == 0 {
+		return nil, fmt.Errorf("no inspect data returned")
+	}
+
+	return &inspectData[0], nil
+}
+
+// checkPulledPlatform guards against the runtime resolving a multi-arch tag
+// to the wrong manifest, e.g. handing back a local arm/v6 image when
+// arm/v7 was asked for, which would otherwise only show up at run time.
+func checkPulledPlatform(image string, info *imageInspect, want types.Platform) error {
+	got := types.Platform{
+		OS:           info.OS,
+		Architecture: info.Architecture,
+		Variant:      info.Variant,
+	}
+
+	if !want.Matches(got) {
+		return fmt.Errorf("image %s resolved to %s, expected %s", image, got.Normalize().String(), want.String())
+	}
+
+	return nil
+}
+
+// qemuArch returns the architecture name used by qemu-<arch>-static for a
+// platform, or "" if it needs no emulator binary.
+func qemuArch(platform types.Platform) string {
+	switch platform.Architecture {
+	case "arm64":
+		return "aarch64"
+	case "arm":
+		return "arm"
+	case "386":
+		return "i386"
+	case "ppc64le":
+		return "ppc64le"
+	case "s390x":
+		return "s390x"
+	case "riscv64":
+		return "riscv64"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,165 @@
+package executors
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// processTable tracks the commands each build has running, keyed by the
+// build's work directory, so a cancelled build can kill them mid-step.
+type processTable struct {
+	mu        sync.Mutex
+	commands  map[string]map[*exec.Cmd]bool
+	cleanups  map[string]map[string]func()
+	cancelled map[string]bool
+}
+
+var processes = &processTable{
+	commands:  make(map[string]map[*exec.Cmd]bool),
+	cleanups:  make(map[string]map[string]func()),
+	cancelled: make(map[string]bool),
+}
+
+// startCommand starts cmd in its own process group so that cancelling the
+// build also reaches whatever it forked (shells, chroot children, the
+// podman/docker client's helpers).
+func startCommand(workDir string, cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+
+	if processes.cancelled[workDir] {
+		return fmt.Errorf("build cancelled")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if processes.commands[workDir] == nil {
+		processes.commands[workDir] = make(map[*exec.Cmd]bool)
+	}
+	processes.commands[workDir][cmd] = true
+	return nil
+}
+
+func waitCommand(workDir string, cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	processes.mu.Lock()
+	delete(processes.commands[workDir], cmd)
+	cancelled := processes.cancelled[workDir]
+	processes.mu.Unlock()
+
+	if err != nil && cancelled {
+		return fmt.Errorf("build cancelled")
+	}
+	return err
+}
+
+// runCommand is the cancellable equivalent of cmd.CombinedOutput.
+func runCommand(workDir string, cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := startCommand(workDir, cmd); err != nil {
+		return nil, err
+	}
+
+	err := waitCommand(workDir, cmd)
+	return output.Bytes(), err
+}
+
+// onCancel registers cleanup to run if the build is cancelled, for state a
+// process-group kill can't reach, such as containers owned by a daemon.
+// The returned function unregisters it once the step is done.
+func onCancel(workDir, name string, cleanup func()) func() {
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+
+	if processes.cleanups[workDir] == nil {
+		processes.cleanups[workDir] = make(map[string]func())
+	}
+	processes.cleanups[workDir][name] = cleanup
+
+	return func() {
+		processes.mu.Lock()
+		defer processes.mu.Unlock()
+		delete(processes.cleanups[workDir], name)
+	}
+}
+
+// CancelProcesses kills every command running for the build using workDir
+// and makes any further commands for it fail to start. It returns the
+// number of process groups killed.
+func CancelProcesses(workDir string) int {
+	return killProcesses(workDir, true)
+}
+
+// KillProcesses kills the commands running for the build using workDir
+// without cancelling it, for stopping a single step (such as one that
+// outgrew the disk limit) while later steps and platforms still run.
+func KillProcesses(workDir string) int {
+	return killProcesses(workDir, false)
+}
+
+func killProcesses(workDir string, cancel bool) int {
+	processes.mu.Lock()
+	if cancel {
+		processes.cancelled[workDir] = true
+	}
+
+	var pids []int
+	for cmd := range processes.commands[workDir] {
+		if cmd.Process != nil {
+			pids = append(pids, cmd.Process.Pid)
+		}
+	}
+
+	// Cleanups stay registered after a kill; the step that registered
+	// them removes them once it returns.
+	var cleanups []func()
+	for _, cleanup := range processes.cleanups[workDir] {
+		cleanups = append(cleanups, cleanup)
+	}
+	if cancel {
+		delete(processes.cleanups, workDir)
+	}
+	processes.mu.Unlock()
+
+	for _, pid := range pids {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+
+	return len(pids)
+}
+
+// Cancelled reports whether the build using workDir has been cancelled, for
+// work outside the executors, such as pushing, that should stop too.
+func Cancelled(workDir string) bool {
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+	return processes.cancelled[workDir]
+}
+
+// ReleaseProcesses forgets the build using workDir once it has finished.
+func ReleaseProcesses(workDir string) {
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+
+	delete(processes.commands, workDir)
+	delete(processes.cleanups, workDir)
+	delete(processes.cancelled, workDir)
+}
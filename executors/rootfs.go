@@ -0,0 +1,270 @@
+package executors
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/internal/ocilayout"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// isLocalSource reports whether a source operation seeds its root
+// filesystem from the host rather than from a registry image.
+func isLocalSource(operation *types.Operation) bool {
+	return operation.Metadata["source"] != ""
+}
+
+// seedSource populates baseDir for FROM dir:// and FROM oci-layout://.
+func seedSource(operation *types.Operation, workDir, baseDir string, platform types.Platform, result *types.OperationResult) (*types.OperationResult, error) {
+	image := operation.Metadata["image"]
+	path := operation.Metadata["path"]
+
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		result.Error = fmt.Sprintf("failed to create base directory: %v", err)
+		return result, nil
+	}
+
+	var env []string
+	switch operation.Metadata["source"] {
+	case types.SourceDir:
+		cmd := exec.Command("cp", "-a", path+"/.", baseDir+"/")
+		if output, err := runCommand(workDir, cmd); err != nil {
+			result.Error = fmt.Sprintf("failed to copy root filesystem from %s: %v, output: %s", path, err, string(output))
+			return result, nil
+		}
+	case types.SourceOCILayout:
+		// The frontend pins the manifest it resolved, so the step unpacks
+		// exactly the image its cache key was computed for.
+		ref := operation.Metadata["ref"]
+		if digest := operation.Metadata["digest"]; digest != "" {
+			ref = digest
+		}
+		config, err := extractLayoutImage(path, ref, platform, baseDir)
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to load %s: %v", image, err)
+			return result, nil
+		}
+		env = config.Config.Env
+	default:
+		result.Error = fmt.Sprintf("unsupported source %q", operation.Metadata["source"])
+		return result, nil
+	}
+
+	result.Success = true
+	result.Outputs = operation.Outputs
+	result.Environment = map[string]string{
+		"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	}
+	for _, entry := range env {
+		if key, value, found := strings.Cut(entry, "="); found {
+			result.Environment[key] = value
+		}
+	}
+
+	return result, nil
+}
+
+// extractLayoutImage unpacks the image ref from an OCI layout into rootfs;
+// see ocilayout.Resolve.
+func extractLayoutImage(layoutDir, ref string, platform types.Platform, rootfs string) (*ocilayout.ImageConfig, error) {
+	descriptor, manifest, err := ocilayout.Resolve(layoutDir, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ocilayout.ImageConfig
+	if err := ocilayout.ReadBlob(layoutDir, manifest.Config.Digest, &config); err != nil {
+		return nil, err
+	}
+	if config.OS != "" {
+		info := &imageInspect{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+		if err := checkPulledPlatform(descriptor.Digest, info, platform); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := extractLayoutLayer(layoutDir, layer, rootfs); err != nil {
+			return nil, fmt.Errorf("failed to extract layer %s: %v", layer.Digest, err)
+		}
+	}
+
+	return &config, nil
+}
+
+func extractLayoutLayer(layoutDir string, layer ocilayout.Descriptor, rootfs string) error {
+	path, err := ocilayout.BlobPath(layoutDir, layer.Digest)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	reader := io.TeeReader(file, hash)
+
+	var stream io.Reader = reader
+	switch {
+	case strings.HasSuffix(layer.MediaType, "+gzip") || strings.HasSuffix(layer.MediaType, ".gzip"):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		stream = gz
+	case strings.HasSuffix(layer.MediaType, "+zstd"):
+		return fmt.Errorf("zstd layers are not supported")
+	}
+
+	if err := extractTar(stream, rootfs); err != nil {
+		return err
+	}
+
+	// Drain trailing padding so the digest covers the whole blob.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("sha256:%x", hash.Sum(nil)); got != layer.Digest {
+		return fmt.Errorf("blob has digest %s", got)
+	}
+	return nil
+}
+
+// extractTar applies a layer tarball to rootfs, honoring whiteouts. Device
+// nodes are skipped; the runtime provides /dev when steps run.
+func extractTar(r io.Reader, rootfs string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(filepath.Clean("/"+header.Name), "/")
+		if name == "" {
+			continue
+		}
+		dir, err := resolveInRoot(rootfs, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(name)
+		target := filepath.Join(dir, base)
+
+		if base == ".wh..wh..opq" {
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				os.RemoveAll(filepath.Join(dir, entry.Name()))
+			}
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, ".wh.")))
+			continue
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+			os.Chmod(target, mode)
+		case tar.TypeReg:
+			os.RemoveAll(target)
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.RemoveAll(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := resolveInRoot(rootfs, strings.TrimPrefix(filepath.Clean("/"+header.Linkname), "/"))
+			if err != nil {
+				return err
+			}
+			os.RemoveAll(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		if os.Geteuid() == 0 {
+			os.Lchown(target, header.Uid, header.Gid)
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, header.ModTime, header.ModTime)
+		}
+	}
+}
+
+// resolveInRoot follows symlinks in the relative path name as if rootfs
+// were "/", so a layer can't write outside rootfs through a symlink an
+// earlier layer created.
+func resolveInRoot(rootfs, name string) (string, error) {
+	current := ""
+	remaining := name
+	for links := 0; remaining != ""; {
+		var part string
+		part, remaining, _ = strings.Cut(remaining, "/")
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if current = filepath.Dir(current); current == "." {
+				current = ""
+			}
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many symlinks resolving %s", name)
+		}
+		link, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			current = ""
+		}
+		remaining = strings.TrimPrefix(link, "/") + "/" + remaining
+	}
+
+	return filepath.Join(rootfs, current), nil
+}
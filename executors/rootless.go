@@ -91,18 +91,44 @@ func (e *RootlessExecutor) executeSource(operation *types.Operation, workDir str
 		return result, nil
 	}
 
+	if isLocalSource(operation) {
+		if err := e.setupRootlessQEMU(platform); err != nil {
+			result.Error = fmt.Sprintf("failed to setup rootless QEMU for %s: %v", platform.String(), err)
+			return result, nil
+		}
+		return seedSource(operation, workDir, filepath.Join(workDir, "base", platform.String()), platform, result)
+	}
+
 	// Use rootless container runtime
 	cmd := e.buildRootlessCommand([]string{
 		"pull", "--platform", platform.String(), image,
 	})
 
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(workDir, cmd)
 	if err != nil {
 		result.Error = fmt.Sprintf("failed to pull image %s for %s: %v, output: %s", 
 			image, platform.String(), err, string(output))
 		return result, nil
 	}
 
+	inspectCmd := e.buildRootlessCommand([]string{"image", "inspect", image})
+	inspectOutput, err := inspectCmd.Output()
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to inspect image %s: %v", image, err)
+		return result, nil
+	}
+
+	info, err := parseImageInspect(inspectOutput)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to inspect image %s: %v", image, err)
+		return result, nil
+	}
+
+	if err := checkPulledPlatform(image, info, platform); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+
 	if err := e.setupRootlessQEMU(platform); err != nil {
 		result.Error = fmt.Sprintf("failed to setup rootless QEMU for %s: %v", platform.String(), err)
 		return result, nil
@@ -117,7 +143,7 @@ func (e *RootlessExecutor) executeSource(operation *types.Operation, workDir str
 	// Extract image using rootless container
 	containerName := fmt.Sprintf("ossb-rootless-extract-%d", time.Now().UnixNano())
 	createCmd := e.buildRootlessCommand([]string{
-		"create", "--platform", platform.String(), "--name", containerName, image,
+		"create", "--platform", platform.String(), "--name", containerName, info.ID,
 	})
 	
 	if output, err := createCmd.CombinedOutput(); err != nil {
@@ -140,22 +166,22 @@ func (e *RootlessExecutor) executeSource(operation *types.Operation, workDir str
 	tarCmd.Stdin = pipeReader
 	tarCmd.Stderr = os.Stderr
 
-	if err := exportCmd.Start(); err != nil {
+	if err := startCommand(workDir, exportCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to start rootless export: %v", err)
 		return result, nil
 	}
 
-	if err := tarCmd.Start(); err != nil {
+	if err := startCommand(workDir, tarCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to start tar extraction: %v", err)
 		return result, nil
 	}
 
-	if err := exportCmd.Wait(); err != nil {
+	if err := waitCommand(workDir, exportCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to export rootless container: %v", err)
 		return result, nil
 	}
 
-	if err := tarCmd.Wait(); err != nil {
+	if err := waitCommand(workDir, tarCmd); err != nil {
 		result.Error = fmt.Sprintf("failed to extract tar: %v", err)
 		return result, nil
 	}
@@ -194,9 +220,17 @@ func (e *RootlessExecutor) executeExec(operation *types.Operation, workDir strin
 		}
 	}
 
+	// Podman's conmon and dockerd outlive a killed client, so cancelling
+	// the build has to remove the container by name.
+	containerName := fmt.Sprintf("ossb-rootless-run-%d", time.Now().UnixNano())
+	release := onCancel(workDir, containerName, func() {
+		e.buildRootlessCommand([]string{"rm", "-f", containerName}).Run()
+	})
+	defer release()
+
 	// Build rootless container run command
 	runArgs := []string{
-		"run", "--rm", "--platform", platform.String(),
+		"run", "--rm", "--name", containerName, "--platform", platform.String(),
 		"--user", fmt.Sprintf("%d:%d", e.currentUID, e.currentGID),
 		"-v", fmt.Sprintf("%s:/workspace:Z", baseDir),
 		"-w", operation.WorkDir,
@@ -216,11 +250,12 @@ func (e *RootlessExecutor) executeExec(operation *types.Operation, workDir strin
 	}
 
 	cmd := e.buildRootlessCommand(runArgs)
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(workDir, cmd)
 	if err != nil {
 		result.Error = fmt.Sprintf("rootless command failed: %v, output: %s", err, string(output))
 		return result, nil
 	}
+	result.Log = string(output)
 
 	if err := e.captureRootlessChanges(baseDir, layerDir); err != nil {
 		result.Error = fmt.Sprintf("failed to capture rootless changes: %v", err)
@@ -267,14 +302,30 @@ func (e *RootlessExecutor) executeFile(operation *types.Operation, workDir strin
 
 	sources := operation.Inputs[1:]
 
+	matcher, contextDir, err := contextMatcher(operation)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid .dockerignore: %v", err)
+		return result, nil
+	}
+
 	switch operationType {
 	case "copy":
-		if err := e.copyFilesRootless(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.copyFilesRootless(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("rootless copy failed: %v", err)
 			return result, nil
 		}
 	case "add":
-		if err := e.addFilesRootless(sources, destPath); err != nil {
+		if matcher != nil {
+			err = copyFromContext(sources, destPath, strings.HasSuffix(dest, "/"), contextDir, matcher)
+		} else {
+			err = e.addFilesRootless(sources, destPath)
+		}
+		if err != nil {
 			result.Error = fmt.Sprintf("rootless add failed: %v", err)
 			return result, nil
 		}
@@ -296,6 +347,12 @@ func (e *RootlessExecutor) executeFile(operation *types.Operation, workDir strin
 }
 
 func (e *RootlessExecutor) executeMeta(operation *types.Operation, workDir string, result *types.OperationResult) (*types.OperationResult, error) {
+	platform := operation.Platform
+	if platform.OS == "" {
+		platform = types.GetHostPlatform()
+	}
+	recordResolvedUser(operation, filepath.Join(workDir, "base", platform.String()), result)
+
 	result.Success = true
 	result.Outputs = operation.Outputs
 	result.Environment = operation.Environment
@@ -355,23 +412,12 @@ func (e *RootlessExecutor) setupRootlessQEMU(platform types.Platform) error {
 	}
 
 	// For rootless mode, we use user-mode QEMU emulation
-	qemuArch := ""
-	switch platform.Architecture {
-	case "arm64":
-		qemuArch = "aarch64"
-	case "arm":
-		qemuArch = "arm"
-	case "386":
-		qemuArch = "i386"
-	case "ppc64le":
-		qemuArch = "ppc64le"
-	case "s390x":
-		qemuArch = "s390x"
-	default:
+	arch := qemuArch(platform)
+	if arch == "" {
 		return nil
 	}
 
-	qemuBinary := fmt.Sprintf("qemu-%s-static", qemuArch)
+	qemuBinary := fmt.Sprintf("qemu-%s-static", arch)
 	if _, err := exec.LookPath(qemuBinary); err != nil {
 		// Try to install binfmt support using rootless container
 		cmd := e.buildRootlessCommand([]string{
@@ -0,0 +1,182 @@
+package executors
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+type passwdEntry struct {
+	name string
+	uid  string
+	gid  string
+}
+
+type groupEntry struct {
+	name string
+	gid  string
+}
+
+// resolveUser maps a USER value such as "app" or "app:staff" to a numeric
+// uid:gid using the rootfs's /etc/passwd and /etc/group. Kubernetes can only
+// verify runAsNonRoot against numeric users, so names are resolved at build
+// time. When a name cannot be resolved the original value is returned along
+// with a warning.
+func resolveUser(rootfs, spec string) (string, string) {
+	if spec == "" {
+		return spec, ""
+	}
+
+	userPart, groupPart := spec, ""
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		userPart, groupPart = spec[:idx], spec[idx+1:]
+	}
+
+	if isNumericID(userPart) && (groupPart == "" || isNumericID(groupPart)) {
+		if groupPart != "" {
+			return spec, ""
+		}
+		users, err := readPasswd(rootfs)
+		if err != nil {
+			return spec, ""
+		}
+		for _, u := range users {
+			if u.uid == userPart {
+				return userPart + ":" + u.gid, ""
+			}
+		}
+		return spec, ""
+	}
+
+	users, err := readPasswd(rootfs)
+	if err != nil {
+		if userPart == "root" && (groupPart == "" || groupPart == "root") {
+			return "0:0", ""
+		}
+		return spec, fmt.Sprintf("cannot resolve USER %q: %v", spec, err)
+	}
+
+	uid, gid := userPart, ""
+	if !isNumericID(userPart) {
+		found := false
+		for _, u := range users {
+			if u.name == userPart {
+				uid, gid = u.uid, u.gid
+				found = true
+				break
+			}
+		}
+		if !found {
+			return spec, fmt.Sprintf("USER %q does not exist in the image's /etc/passwd", userPart)
+		}
+	} else {
+		for _, u := range users {
+			if u.uid == userPart {
+				gid = u.gid
+				break
+			}
+		}
+	}
+
+	if groupPart != "" {
+		if isNumericID(groupPart) {
+			gid = groupPart
+		} else {
+			groups, err := readGroup(rootfs)
+			if err != nil {
+				return spec, fmt.Sprintf("cannot resolve group %q for USER %q: %v", groupPart, spec, err)
+			}
+			found := false
+			for _, g := range groups {
+				if g.name == groupPart {
+					gid = g.gid
+					found = true
+					break
+				}
+			}
+			if !found {
+				return spec, fmt.Sprintf("group %q does not exist in the image's /etc/group", groupPart)
+			}
+		}
+	}
+
+	if gid == "" {
+		return uid, ""
+	}
+	return uid + ":" + gid, ""
+}
+
+func isNumericID(value string) bool {
+	if value == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(value, 10, 32)
+	return err == nil
+}
+
+func readPasswd(rootfs string) ([]passwdEntry, error) {
+	content, err := os.ReadFile(filepath.Join(rootfs, "etc", "passwd"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("image has no /etc/passwd")
+		}
+		return nil, err
+	}
+
+	var entries []passwdEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 4 {
+			continue
+		}
+		entries = append(entries, passwdEntry{name: parts[0], uid: parts[2], gid: parts[3]})
+	}
+
+	return entries, nil
+}
+
+func readGroup(rootfs string) ([]groupEntry, error) {
+	content, err := os.ReadFile(filepath.Join(rootfs, "etc", "group"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("image has no /etc/group")
+		}
+		return nil, err
+	}
+
+	var entries []groupEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 3 {
+			continue
+		}
+		entries = append(entries, groupEntry{name: parts[0], gid: parts[2]})
+	}
+
+	return entries, nil
+}
+
+func recordResolvedUser(operation *types.Operation, rootfs string, result *types.OperationResult) {
+	user := operation.Metadata["user"]
+	if user == "" {
+		return
+	}
+
+	resolved, warning := resolveUser(rootfs, user)
+	result.Metadata = map[string]string{"user": resolved}
+	if warning != "" {
+		result.Warnings = append(result.Warnings, warning)
+	}
+}
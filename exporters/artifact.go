@@ -0,0 +1,258 @@
+package exporters
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+const (
+	mediaTypeEmptyConfig  = "application/vnd.oci.empty.v1+json"
+	mediaTypeArtifactFile = "application/vnd.oci.image.layer.v1.tar"
+	titleAnnotation       = "org.opencontainers.image.title"
+)
+
+// mediaTypePattern is the image-spec's mediaType definition.
+var mediaTypePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// ArtifactExporter packages files produced by the build as an OCI artifact:
+// an image manifest with an artifactType, the empty config and one blob per
+// file, the way ORAS does. Directories are stored as gzipped tarballs.
+type ArtifactExporter struct{}
+
+func init() {
+	RegisterExporter("artifact", &ArtifactExporter{})
+}
+
+// ValidateArtifact checks the artifact settings of a build that produces
+// one, so mistakes are reported before the build runs rather than after.
+func ValidateArtifact(config *types.BuildConfig) error {
+	if config.Output != "artifact" && len(config.ArtifactPaths) == 0 {
+		return nil
+	}
+
+	if config.ArtifactType == "" {
+		return fmt.Errorf("artifact export requires an artifact type")
+	}
+	if !mediaTypePattern.MatchString(config.ArtifactType) {
+		return fmt.Errorf("invalid artifact type %q: must be a media type such as application/vnd.example.tool.v1", config.ArtifactType)
+	}
+	if len(config.ArtifactPaths) == 0 {
+		return fmt.Errorf("artifact export requires at least one artifact path")
+	}
+	for _, spec := range config.ArtifactPaths {
+		if _, mediaType := parseArtifactPath(spec); mediaType != "" && !mediaTypePattern.MatchString(mediaType) {
+			return fmt.Errorf("invalid media type %q for artifact path %s", mediaType, spec)
+		}
+	}
+
+	if config.Push {
+		if config.Registry == "" {
+			return fmt.Errorf("pushing an artifact requires a registry")
+		}
+		if len(artifactTags(config)) == 0 {
+			return fmt.Errorf("pushing an artifact requires a tag")
+		}
+	}
+	return nil
+}
+
+// artifactTags returns the tags the artifact is stored and pushed under. As
+// the main output the artifact takes the build's tags; next to an image it
+// needs its own so it doesn't overwrite the image's.
+func artifactTags(config *types.BuildConfig) []string {
+	if config.Output == "artifact" && len(config.ArtifactTags) == 0 {
+		return config.Tags
+	}
+	return config.ArtifactTags
+}
+
+func (e *ArtifactExporter) Export(result *types.BuildResult, config *types.BuildConfig, workDir string) error {
+	if err := ValidateArtifact(config); err != nil {
+		return err
+	}
+
+	primary := config.Output == "artifact"
+	tags := artifactTags(config)
+
+	outputDir := filepath.Join(workDir, "artifact")
+	if config.LayoutDir != "" {
+		outputDir = config.LayoutDir
+	}
+
+	layout, err := OpenLayout(outputDir)
+	if err != nil {
+		return err
+	}
+
+	platform := types.GetHostPlatform()
+	if len(config.Platforms) > 0 {
+		platform = config.Platforms[0]
+	}
+
+	configData := []byte("{}")
+	configDigest, err := layout.WriteBlob(configData)
+	if err != nil {
+		return fmt.Errorf("failed to write artifact config: %v", err)
+	}
+
+	var layers []OCIDescriptor
+	for _, spec := range config.ArtifactPaths {
+		path, mediaType := parseArtifactPath(spec)
+
+		source := resolveBuildPath(workDir, platform, path)
+		if source == "" {
+			return fmt.Errorf("artifact path %s was not produced by the build", path)
+		}
+
+		descriptor, err := e.writeFile(layout, source, mediaType)
+		if err != nil {
+			return fmt.Errorf("failed to package %s: %v", path, err)
+		}
+		descriptor.Annotations = map[string]string{titleAnnotation: filepath.Base(path)}
+		layers = append(layers, *descriptor)
+	}
+
+	manifest := &OCIManifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		ArtifactType:  config.ArtifactType,
+		Config: OCIDescriptor{
+			MediaType: mediaTypeEmptyConfig,
+			Digest:    configDigest,
+			Size:      int64(len(configData)),
+		},
+		Layers: layers,
+		Annotations: map[string]string{
+			"org.opencontainers.image.created": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact manifest: %v", err)
+	}
+
+	if err := validateOCI(schemaImageManifest, manifestData); err != nil {
+		return fmt.Errorf("invalid artifact manifest: %v", err)
+	}
+
+	manifestDigest, err := layout.WriteBlob(manifestData)
+	if err != nil {
+		return fmt.Errorf("failed to write artifact manifest: %v", err)
+	}
+
+	descriptor := OCIManifestRef{
+		MediaType:    manifest.MediaType,
+		Digest:       manifestDigest,
+		Size:         int64(len(manifestData)),
+		ArtifactType: config.ArtifactType,
+	}
+
+	if err := layout.AddManifest(descriptor, tags); err != nil {
+		return fmt.Errorf("failed to update artifact index: %v", err)
+	}
+
+	result.Metadata["artifact.digest"] = manifestDigest
+	result.Metadata["artifact.type"] = config.ArtifactType
+	result.Metadata["artifact.output"] = outputDir
+	if primary {
+		result.OutputPath = outputDir
+		result.ImageID = manifestDigest
+		result.Digest = manifestDigest
+	}
+
+	if config.Push && config.Registry != "" {
+		if err := checkCancelled(workDir); err != nil {
+			return err
+		}
+		if err := pushTags(layout, manifest, manifestData, tags, config.Registry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *ArtifactExporter) writeFile(layout *OCILayout, source, mediaType string) (*OCIDescriptor, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		blob, err := WriteLayerBlob(source, filepath.Join(layout.Dir(), "blobs"))
+		if err != nil {
+			return nil, err
+		}
+		if mediaType != "" {
+			blob.Descriptor.MediaType = mediaType
+		}
+		return &blob.Descriptor, nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := layout.WriteBlob(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == "" {
+		mediaType = mediaTypeArtifactFile
+	}
+
+	return &OCIDescriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// parseArtifactPath splits "path[:media/type]", the syntax ORAS uses for
+// files on its command line.
+func parseArtifactPath(spec string) (string, string) {
+	if idx := strings.LastIndex(spec, ":"); idx > 0 && strings.Contains(spec[idx+1:], "/") {
+		return spec[:idx], spec[idx+1:]
+	}
+	return spec, ""
+}
+
+// resolveBuildPath finds path in the build's filesystem, checking the
+// newest layer first and falling back to the base image. It returns "" if
+// no layer contains it.
+func resolveBuildPath(workDir string, platform types.Platform, path string) string {
+	layersDir := filepath.Join(workDir, "layers", platform.String())
+	baseDir := filepath.Join(workDir, "base", platform.String())
+	if _, err := os.Stat(layersDir); err != nil {
+		layersDir = filepath.Join(workDir, "layers")
+		baseDir = filepath.Join(workDir, "base")
+	}
+
+	roots := []string{}
+	if dirs, err := LayerDirs(layersDir); err == nil {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			roots = append(roots, dirs[i])
+		}
+	}
+	roots = append(roots, baseDir)
+
+	rel := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	for _, root := range roots {
+		candidate := filepath.Join(root, rel)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
@@ -1,7 +1,6 @@
 package exporters
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -20,15 +19,17 @@ func init() {
 type OCIManifest struct {
 	SchemaVersion int                    `json:"schemaVersion"`
 	MediaType     string                 `json:"mediaType"`
+	ArtifactType  string                 `json:"artifactType,omitempty"`
 	Config        OCIDescriptor          `json:"config"`
 	Layers        []OCIDescriptor        `json:"layers"`
 	Annotations   map[string]string      `json:"annotations,omitempty"`
 }
 
 type OCIDescriptor struct {
-	MediaType string `json:"mediaType"`
-	Digest    string `json:"digest"`
-	Size      int64  `json:"size"`
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 type OCIImageConfig struct {
@@ -66,47 +67,107 @@ type OCIHistory struct {
 
 func (e *ImageExporter) Export(result *types.BuildResult, config *types.BuildConfig, workDir string) error {
 	imageDir := filepath.Join(workDir, "image")
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return fmt.Errorf("failed to create image directory: %v", err)
+	if config.LayoutDir != "" {
+		imageDir = config.LayoutDir
 	}
 
-	layersDir := filepath.Join(workDir, "layers")
-	
-	layers, err := e.collectLayers(layersDir)
+	layout, err := OpenLayout(imageDir)
+	if err != nil {
+		return err
+	}
+
+	platform := types.GetHostPlatform()
+	if len(config.Platforms) > 0 {
+		platform = config.Platforms[0]
+	}
+
+	layersDir := filepath.Join(workDir, "layers", platform.String())
+	if _, err := os.Stat(layersDir); err != nil {
+		layersDir = filepath.Join(workDir, "layers")
+	}
+
+	manifest, manifestData, descriptor, err := e.writeImage(layout, layersDir, platform, e.buildContainerConfig(result.Metadata), e.buildHistory(result))
+	if err != nil {
+		return err
+	}
+
+	// With --platform-tag-suffix the image also gets its architecture
+	// tags (app:1.0-arm64), even when it is the only platform built.
+	tags := config.Tags
+	if platformResult := result.PlatformResults[platform.String()]; platformResult != nil {
+		tags = append(append([]string{}, config.Tags...), platformResult.Tags...)
+	}
+
+	if err := layout.AddManifest(descriptor, tags); err != nil {
+		return fmt.Errorf("failed to update image index: %v", err)
+	}
+
+	result.OutputPath = imageDir
+	result.Digest = descriptor.Digest
+	if platformResult := result.PlatformResults[platform.String()]; platformResult != nil {
+		platformResult.ManifestID = descriptor.Digest
+	}
+	if len(config.Tags) > 0 {
+		result.ImageID = config.Tags[0]
+	} else {
+		result.ImageID = manifest.Config.Digest
+	}
+
+	if config.Push && config.Registry != "" {
+		if err := checkCancelled(workDir); err != nil {
+			return err
+		}
+		if err := pushTags(layout, manifest, manifestData, tags, config.Registry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeImage packs the layers under layersDir into layout and writes the
+// image config and manifest for platform, returning the manifest and the
+// descriptor that points at it.
+func (e *ImageExporter) writeImage(layout *OCILayout, layersDir string, platform types.Platform, containerConfig OCIContainerConfig, history []OCIHistory) (*OCIManifest, []byte, OCIManifestRef, error) {
+	var descriptor OCIManifestRef
+
+	layerBlobs, err := e.collectLayers(layersDir, layout)
 	if err != nil {
-		return fmt.Errorf("failed to collect layers: %v", err)
+		return nil, nil, descriptor, fmt.Errorf("failed to collect layers: %v", err)
+	}
+
+	layers := []string{}
+	layerDescriptors := []OCIDescriptor{}
+	for _, blob := range layerBlobs {
+		layers = append(layers, blob.DiffID)
+		layerDescriptors = append(layerDescriptors, blob.Descriptor)
 	}
 
 	imageConfig := &OCIImageConfig{
 		Created:      time.Now(),
-		Architecture: "amd64",
-		OS:           "linux",
-		Config:       e.buildContainerConfig(result.Metadata),
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Variant:      platform.Variant,
+		Config:       containerConfig,
 		RootFS: OCIRootFS{
 			Type:    "layers",
 			DiffIDs: layers,
 		},
-		History: e.buildHistory(result),
+		History: history,
 	}
 
 	configData, err := json.Marshal(imageConfig)
 	if err != nil {
-		return fmt.Errorf("failed to marshal image config: %v", err)
+		return nil, nil, descriptor, fmt.Errorf("failed to marshal image config: %v", err)
 	}
 
-	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(configData))
-	configPath := filepath.Join(imageDir, configDigest[7:]+".json")
-	if err := os.WriteFile(configPath, configData, 0644); err != nil {
-		return fmt.Errorf("failed to write config: %v", err)
+	if err := validateOCI(schemaImageConfig, configData); err != nil {
+		return nil, nil, descriptor, fmt.Errorf("invalid image config: %v", err)
 	}
 
-	layerDescriptors := make([]OCIDescriptor, len(layers))
-	for i, layer := range layers {
-		layerDescriptors[i] = OCIDescriptor{
-			MediaType: "application/vnd.oci.image.layer.v1.tar",
-			Digest:    layer,
-			Size:      0, 
-		}
+	configDigest, err := layout.WriteBlob(configData)
+	if err != nil {
+		return nil, nil, descriptor, fmt.Errorf("failed to write config: %v", err)
 	}
 
 	manifest := &OCIManifest{
@@ -123,49 +184,50 @@ func (e *ImageExporter) Export(result *types.BuildResult, config *types.BuildCon
 		},
 	}
 
-	if len(config.Tags) > 0 {
-		manifest.Annotations["org.opencontainers.image.ref.name"] = config.Tags[0]
-	}
-
 	manifestData, err := json.Marshal(manifest)
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %v", err)
+		return nil, nil, descriptor, fmt.Errorf("failed to marshal manifest: %v", err)
 	}
 
-	manifestPath := filepath.Join(imageDir, "manifest.json")
-	if err := os.WriteFile(manifestPath, manifestData, 0644); err != nil {
-		return fmt.Errorf("failed to write manifest: %v", err)
+	if err := validateOCI(schemaImageManifest, manifestData); err != nil {
+		return nil, nil, descriptor, fmt.Errorf("invalid image manifest: %v", err)
 	}
 
-	result.OutputPath = imageDir
-	if len(config.Tags) > 0 {
-		result.ImageID = config.Tags[0]
-	} else {
-		result.ImageID = configDigest
+	manifestDigest, err := layout.WriteBlob(manifestData)
+	if err != nil {
+		return nil, nil, descriptor, fmt.Errorf("failed to write manifest: %v", err)
 	}
 
-	return nil
+	descriptor = OCIManifestRef{
+		MediaType: manifest.MediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifestData)),
+		Platform: &OCIPlatformDescriptor{
+			Architecture: platform.Architecture,
+			OS:           platform.OS,
+			Variant:      platform.Variant,
+		},
+	}
+
+	return manifest, manifestData, descriptor, nil
 }
 
-func (e *ImageExporter) collectLayers(layersDir string) ([]string, error) {
-	var layers []string
-	
-	entries, err := os.ReadDir(layersDir)
-	if os.IsNotExist(err) {
-		return layers, nil
-	}
+func (e *ImageExporter) collectLayers(layersDir string, layout *OCILayout) ([]*LayerBlob, error) {
+	dirs, err := LayerDirs(layersDir)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			layerHash := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(entry.Name())))
-			layers = append(layers, layerHash)
+	var blobs []*LayerBlob
+	for _, dir := range dirs {
+		blob, err := WriteLayerBlob(dir, filepath.Join(layout.Dir(), "blobs"))
+		if err != nil {
+			return nil, err
 		}
+		blobs = append(blobs, blob)
 	}
 
-	return layers, nil
+	return blobs, nil
 }
 
 func (e *ImageExporter) buildContainerConfig(metadata map[string]string) OCIContainerConfig {
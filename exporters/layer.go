@@ -0,0 +1,183 @@
+package exporters
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const mediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+// LayerBlob is a layer directory packed as a gzipped tar blob.
+type LayerBlob struct {
+	Descriptor OCIDescriptor
+	DiffID     string
+	Path       string
+}
+
+// WriteLayerBlob packs layerDir into blobsDir/sha256/<digest>. The archive
+// is deterministic (sorted entries, no gzip timestamp), so packing the same
+// directory twice yields the same digest and a registry that already has
+// the blob can skip it.
+func WriteLayerBlob(layerDir, blobsDir string) (*LayerBlob, error) {
+	if err := os.MkdirAll(filepath.Join(blobsDir, "sha256"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(blobsDir, "sha256"), ".layer-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create layer blob: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressedDigest := sha256.New()
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(tmp, compressedDigest, counter))
+
+	diffID := sha256.New()
+	if err := writeLayerTar(layerDir, io.MultiWriter(gz, diffID)); err != nil {
+		return nil, fmt.Errorf("failed to archive layer %s: %v", filepath.Base(layerDir), err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress layer: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write layer blob: %v", err)
+	}
+
+	digest := hexDigest(compressedDigest)
+	blobPath := filepath.Join(blobsDir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+	if err := os.Rename(tmp.Name(), blobPath); err != nil {
+		return nil, fmt.Errorf("failed to write layer blob: %v", err)
+	}
+
+	return &LayerBlob{
+		Descriptor: OCIDescriptor{
+			MediaType: mediaTypeLayerGzip,
+			Digest:    digest,
+			Size:      counter.n,
+		},
+		DiffID: hexDigest(diffID),
+		Path:   blobPath,
+	}, nil
+}
+
+// LayerDirs returns the layer directories under layersDir in build order.
+func LayerDirs(layersDir string) ([]string, error) {
+	entries, err := os.ReadDir(layersDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "layer-") {
+			dirs = append(dirs, filepath.Join(layersDir, entry.Name()))
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return layerIndex(dirs[i]) < layerIndex(dirs[j])
+	})
+
+	return dirs, nil
+}
+
+func layerIndex(dir string) int {
+	var index int
+	fmt.Sscanf(filepath.Base(dir), "layer-%d", &index)
+	return index
+}
+
+func writeLayerTar(root string, w io.Writer) error {
+	var paths []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	tw := tar.NewWriter(w)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		// Access and change times vary between runs; leaving them out keeps
+		// the archive reproducible.
+		header.Uname, header.Gname = "", ""
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
@@ -0,0 +1,243 @@
+package exporters
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+	ociLayoutVersion  = "1.0.0"
+)
+
+// OCILayout is an OCI image layout directory. Images written to the same
+// layout accumulate in its index.json, keyed by their ref.name annotation,
+// so one directory can hold several tags, platforms or builds.
+type OCILayout struct {
+	dir string
+}
+
+func OpenLayout(dir string) (*OCILayout, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create layout directory: %v", err)
+	}
+
+	layoutFile := filepath.Join(dir, "oci-layout")
+	if _, err := os.Stat(layoutFile); os.IsNotExist(err) {
+		data, _ := json.Marshal(map[string]string{"imageLayoutVersion": ociLayoutVersion})
+		if err := os.WriteFile(layoutFile, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write oci-layout: %v", err)
+		}
+	}
+
+	return &OCILayout{dir: dir}, nil
+}
+
+func (l *OCILayout) Dir() string {
+	return l.dir
+}
+
+func (l *OCILayout) WriteBlob(data []byte) (string, error) {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	blobPath := l.BlobPath(digest)
+
+	if _, err := os.Stat(blobPath); err == nil {
+		return digest, nil
+	}
+
+	if err := os.WriteFile(blobPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write blob %s: %v", digest, err)
+	}
+
+	return digest, nil
+}
+
+func (l *OCILayout) BlobPath(digest string) string {
+	return filepath.Join(l.dir, "blobs", "sha256", digest[len("sha256:"):])
+}
+
+func (l *OCILayout) ReadIndex() (*OCIIndex, error) {
+	index := &OCIIndex{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     []OCIManifestRef{},
+	}
+
+	data, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index.json: %v", err)
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %v", err)
+	}
+
+	return index, nil
+}
+
+// AddManifest records a manifest in index.json under each of the given
+// reference names. Every name keeps exactly one entry: exporting a tag
+// again for the same platform replaces it, and exporting it for another
+// platform points the name at a nested image index holding one manifest
+// per platform, so per-platform exports of one tag accumulate the way a
+// registry would show them. With no names the manifest is added untagged
+// unless already present.
+func (l *OCILayout) AddManifest(descriptor OCIManifestRef, refNames []string) error {
+	index, err := l.ReadIndex()
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string][]OCIManifestRef)
+	for _, name := range refNames {
+		previous[name] = nil
+	}
+
+	manifests := []OCIManifestRef{}
+	for _, existing := range index.Manifests {
+		name := existing.Annotations[refNameAnnotation]
+		if _, replaced := previous[name]; name != "" && replaced {
+			previous[name] = append(previous[name], existing)
+			continue
+		}
+		if len(refNames) == 0 && existing.Digest == descriptor.Digest && name == "" {
+			continue
+		}
+		manifests = append(manifests, existing)
+	}
+
+	if len(refNames) == 0 {
+		manifests = append(manifests, descriptor)
+	}
+	for _, name := range refNames {
+		target, err := l.mergePlatforms(previous[name], descriptor)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %v", name, err)
+		}
+		manifests = append(manifests, withRefName(target, name))
+	}
+
+	index.Manifests = manifests
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index.json: %v", err)
+	}
+
+	if err := validateOCI(schemaImageIndex, data); err != nil {
+		return fmt.Errorf("invalid image index: %v", err)
+	}
+
+	indexPath := filepath.Join(l.dir, "index.json")
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index.json: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		return fmt.Errorf("failed to write index.json: %v", err)
+	}
+
+	return nil
+}
+
+// mergePlatforms returns what a tag should point at once descriptor is
+// exported under it: descriptor itself, or a nested index combining it
+// with the tag's manifests for other platforms. Indexes and manifests
+// without a platform replace whatever the tag held.
+func (l *OCILayout) mergePlatforms(previous []OCIManifestRef, descriptor OCIManifestRef) (OCIManifestRef, error) {
+	if descriptor.Platform == nil || isIndexDescriptor(descriptor) {
+		return descriptor, nil
+	}
+
+	var platforms []OCIManifestRef
+	for _, existing := range previous {
+		if !isIndexDescriptor(existing) {
+			platforms = append(platforms, existing)
+			continue
+		}
+
+		var nested OCIIndex
+		data, err := os.ReadFile(l.BlobPath(existing.Digest))
+		if err != nil {
+			return OCIManifestRef{}, fmt.Errorf("failed to read index %s: %v", existing.Digest, err)
+		}
+		if err := json.Unmarshal(data, &nested); err != nil {
+			return OCIManifestRef{}, fmt.Errorf("failed to parse index %s: %v", existing.Digest, err)
+		}
+		platforms = append(platforms, nested.Manifests...)
+	}
+
+	merged := []OCIManifestRef{}
+	for _, existing := range platforms {
+		if existing.Platform == nil || samePlatform(existing.Platform, descriptor.Platform) {
+			continue
+		}
+		merged = append(merged, withRefName(existing, ""))
+	}
+	if len(merged) == 0 {
+		return descriptor, nil
+	}
+	merged = append(merged, withRefName(descriptor, ""))
+
+	nested := OCIIndex{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     merged,
+	}
+	data, err := json.Marshal(nested)
+	if err != nil {
+		return OCIManifestRef{}, fmt.Errorf("failed to marshal image index: %v", err)
+	}
+	if err := validateOCI(schemaImageIndex, data); err != nil {
+		return OCIManifestRef{}, fmt.Errorf("invalid image index: %v", err)
+	}
+	digest, err := l.WriteBlob(data)
+	if err != nil {
+		return OCIManifestRef{}, err
+	}
+
+	return OCIManifestRef{
+		MediaType: nested.MediaType,
+		Digest:    digest,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// withRefName copies descriptor with its ref.name annotation set to name,
+// or removed when name is empty.
+func withRefName(descriptor OCIManifestRef, name string) OCIManifestRef {
+	annotations := make(map[string]string)
+	for key, value := range descriptor.Annotations {
+		if key != refNameAnnotation {
+			annotations[key] = value
+		}
+	}
+	if name != "" {
+		annotations[refNameAnnotation] = name
+	}
+
+	descriptor.Annotations = annotations
+	if len(annotations) == 0 {
+		descriptor.Annotations = nil
+	}
+	return descriptor
+}
+
+func isIndexDescriptor(descriptor OCIManifestRef) bool {
+	return descriptor.MediaType == "application/vnd.oci.image.index.v1+json" ||
+		descriptor.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+}
+
+func samePlatform(a, b *OCIPlatformDescriptor) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.OS == b.OS && a.Architecture == b.Architecture && a.Variant == b.Variant
+}
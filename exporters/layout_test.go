@@ -0,0 +1,122 @@
+package exporters
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// exportPlatform runs the image exporter for one platform into layoutDir,
+// the way separate single-platform builds of one tag would.
+func exportPlatform(t *testing.T, layoutDir, platform string, tags []string) *types.BuildResult {
+	t.Helper()
+
+	workDir := t.TempDir()
+	layersDir := filepath.Join(workDir, "layers", platform, "layer-1")
+	if err := os.MkdirAll(layersDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(layersDir, "arch"), []byte(platform), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := &types.BuildResult{Success: true}
+	config := &types.BuildConfig{
+		Tags:      tags,
+		Platforms: []types.Platform{types.ParsePlatform(platform)},
+		LayoutDir: layoutDir,
+	}
+	if err := (&ImageExporter{}).Export(result, config, workDir); err != nil {
+		t.Fatalf("failed to export %s: %v", platform, err)
+	}
+	return result
+}
+
+// taggedEntries returns the index.json entries carrying ref.name name.
+func taggedEntries(t *testing.T, layout *OCILayout, name string) []OCIManifestRef {
+	t.Helper()
+
+	index, err := layout.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []OCIManifestRef
+	for _, m := range index.Manifests {
+		if m.Annotations[refNameAnnotation] == name {
+			entries = append(entries, m)
+		}
+	}
+	return entries
+}
+
+// nestedPlatforms reads the nested index a tag points at and returns its
+// manifests keyed by platform.
+func nestedPlatforms(t *testing.T, layout *OCILayout, descriptor OCIManifestRef) map[string]string {
+	t.Helper()
+
+	if !isIndexDescriptor(descriptor) {
+		t.Fatalf("tag points at a %s, want an image index", descriptor.MediaType)
+	}
+	data, err := os.ReadFile(layout.BlobPath(descriptor.Digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nested OCIIndex
+	if err := json.Unmarshal(data, &nested); err != nil {
+		t.Fatal(err)
+	}
+
+	platforms := make(map[string]string)
+	for _, m := range nested.Manifests {
+		if m.Platform == nil {
+			t.Fatalf("nested index entry %s has no platform", m.Digest)
+		}
+		if _, tagged := m.Annotations[refNameAnnotation]; tagged {
+			t.Fatalf("nested index entry %s carries a ref.name", m.Digest)
+		}
+		platform := types.Platform{OS: m.Platform.OS, Architecture: m.Platform.Architecture, Variant: m.Platform.Variant}
+		platforms[platform.String()] = m.Digest
+	}
+	return platforms
+}
+
+func TestLayoutTagIsUniqueAcrossPlatforms(t *testing.T) {
+	layoutDir := t.TempDir()
+	layout, err := OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := exportPlatform(t, layoutDir, "linux/amd64", []string{"app:1.0"})
+	if entries := taggedEntries(t, layout, "app:1.0"); len(entries) != 1 || entries[0].Digest != amd64.Digest {
+		t.Fatalf("after one export app:1.0 has %d entries, want the amd64 manifest alone", len(entries))
+	}
+
+	arm64 := exportPlatform(t, layoutDir, "linux/arm64", []string{"app:1.0"})
+	entries := taggedEntries(t, layout, "app:1.0")
+	if len(entries) != 1 {
+		t.Fatalf("app:1.0 has %d entries in index.json, want 1", len(entries))
+	}
+	platforms := nestedPlatforms(t, layout, entries[0])
+	if len(platforms) != 2 || platforms["linux/amd64"] != amd64.Digest || platforms["linux/arm64"] != arm64.Digest {
+		t.Fatalf("app:1.0 holds %v, want the amd64 and arm64 manifests", platforms)
+	}
+
+	// Re-exporting a platform replaces its manifest in the nested index.
+	exportPlatform(t, layoutDir, "linux/arm64", []string{"app:1.0", "app:latest"})
+	entries = taggedEntries(t, layout, "app:1.0")
+	if len(entries) != 1 {
+		t.Fatalf("app:1.0 has %d entries in index.json after re-export, want 1", len(entries))
+	}
+	platforms = nestedPlatforms(t, layout, entries[0])
+	if len(platforms) != 2 || platforms["linux/amd64"] != amd64.Digest {
+		t.Fatalf("app:1.0 holds %v after re-exporting arm64, want amd64 kept and arm64 replaced", platforms)
+	}
+
+	if entries := taggedEntries(t, layout, "app:latest"); len(entries) != 1 || isIndexDescriptor(entries[0]) {
+		t.Fatalf("app:latest has %d entries, want the arm64 manifest alone", len(entries))
+	}
+}
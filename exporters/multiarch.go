@@ -1,15 +1,13 @@
 package exporters
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"sort"
 	"time"
 
+	"github.com/bibin-skaria/ossb/internal/registry"
 	"github.com/bibin-skaria/ossb/internal/types"
 )
 
@@ -27,10 +25,12 @@ type OCIIndex struct {
 }
 
 type OCIManifestRef struct {
-	MediaType string                `json:"mediaType"`
-	Digest    string                `json:"digest"`
-	Size      int64                 `json:"size"`
-	Platform  OCIPlatformDescriptor `json:"platform,omitempty"`
+	MediaType    string                 `json:"mediaType"`
+	Digest       string                 `json:"digest"`
+	Size         int64                  `json:"size"`
+	ArtifactType string                 `json:"artifactType,omitempty"`
+	Platform     *OCIPlatformDescriptor `json:"platform,omitempty"`
+	Annotations  map[string]string      `json:"annotations,omitempty"`
 }
 
 type OCIPlatformDescriptor struct {
@@ -42,6 +42,15 @@ type OCIPlatformDescriptor struct {
 	OSFeatures   []string `json:"os.features,omitempty"`
 }
 
+// platformImage is one platform's image as written to the layout.
+type platformImage struct {
+	platform   types.Platform
+	tags       []string
+	manifest   *OCIManifest
+	data       []byte
+	descriptor OCIManifestRef
+}
+
 func (e *MultiArchExporter) Export(result *types.BuildResult, config *types.BuildConfig, workDir string) error {
 	if !result.MultiArch || len(result.PlatformResults) <= 1 {
 		imageExporter := &ImageExporter{}
@@ -49,52 +58,55 @@ func (e *MultiArchExporter) Export(result *types.BuildResult, config *types.Buil
 	}
 
 	imageDir := filepath.Join(workDir, "multiarch")
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return fmt.Errorf("failed to create multiarch directory: %v", err)
+	if config.LayoutDir != "" {
+		imageDir = config.LayoutDir
+	}
+
+	layout, err := OpenLayout(imageDir)
+	if err != nil {
+		return err
 	}
 
+	platformStrs := make([]string, 0, len(result.PlatformResults))
+	for platformStr := range result.PlatformResults {
+		platformStrs = append(platformStrs, platformStr)
+	}
+	sort.Strings(platformStrs)
+
+	imageExporter := &ImageExporter{}
+	var images []*platformImage
 	var manifestRefs []OCIManifestRef
-	
-	for platformStr, platformResult := range result.PlatformResults {
+
+	for _, platformStr := range platformStrs {
+		platformResult := result.PlatformResults[platformStr]
 		if !platformResult.Success {
 			continue
 		}
 
 		platform := types.ParsePlatform(platformStr)
-		
-		manifest, err := e.buildPlatformManifest(platform, platformResult, config, workDir)
+		layersDir := filepath.Join(workDir, "layers", platform.String())
+
+		manifest, manifestData, descriptor, err := imageExporter.writeImage(layout, layersDir, platform, e.buildContainerConfig(config, platform), e.buildPlatformHistory(platform))
 		if err != nil {
 			return fmt.Errorf("failed to build manifest for %s: %v", platformStr, err)
 		}
 
-		manifestData, err := json.Marshal(manifest)
-		if err != nil {
-			return fmt.Errorf("failed to marshal manifest for %s: %v", platformStr, err)
+		if len(platformResult.Tags) > 0 {
+			if err := layout.AddManifest(descriptor, platformResult.Tags); err != nil {
+				return fmt.Errorf("failed to tag image for %s: %v", platformStr, err)
+			}
 		}
 
-		manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifestData))
-		manifestPath := filepath.Join(imageDir, "manifests", manifestDigest[7:]+".json")
-		
-		if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
-			return fmt.Errorf("failed to create manifest directory: %v", err)
-		}
-		
-		if err := os.WriteFile(manifestPath, manifestData, 0644); err != nil {
-			return fmt.Errorf("failed to write manifest for %s: %v", platformStr, err)
-		}
+		platformResult.ManifestID = descriptor.Digest
 
-		manifestRef := OCIManifestRef{
-			MediaType: "application/vnd.oci.image.manifest.v1+json",
-			Digest:    manifestDigest,
-			Size:      int64(len(manifestData)),
-			Platform: OCIPlatformDescriptor{
-				Architecture: platform.Architecture,
-				OS:           platform.OS,
-				Variant:      platform.Variant,
-			},
-		}
-		
-		manifestRefs = append(manifestRefs, manifestRef)
+		images = append(images, &platformImage{
+			platform:   platform,
+			tags:       platformResult.Tags,
+			manifest:   manifest,
+			data:       manifestData,
+			descriptor: descriptor,
+		})
+		manifestRefs = append(manifestRefs, descriptor)
 	}
 
 	if len(manifestRefs) == 0 {
@@ -120,15 +132,27 @@ func (e *MultiArchExporter) Export(result *types.BuildResult, config *types.Buil
 		return fmt.Errorf("failed to marshal image index: %v", err)
 	}
 
-	indexPath := filepath.Join(imageDir, "index.json")
-	if err := os.WriteFile(indexPath, indexData, 0644); err != nil {
+	if err := validateOCI(schemaImageIndex, indexData); err != nil {
+		return fmt.Errorf("invalid image index: %v", err)
+	}
+
+	indexDigest, err := layout.WriteBlob(indexData)
+	if err != nil {
 		return fmt.Errorf("failed to write image index: %v", err)
 	}
 
-	indexDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(indexData))
-	
+	descriptor := OCIManifestRef{
+		MediaType: index.MediaType,
+		Digest:    indexDigest,
+		Size:      int64(len(indexData)),
+	}
+	if err := layout.AddManifest(descriptor, config.Tags); err != nil {
+		return fmt.Errorf("failed to update image index: %v", err)
+	}
+
 	result.OutputPath = imageDir
 	result.ManifestListID = indexDigest
+	result.Digest = indexDigest
 	if len(config.Tags) > 0 {
 		result.ImageID = config.Tags[0] + "@" + indexDigest
 	} else {
@@ -136,100 +160,19 @@ func (e *MultiArchExporter) Export(result *types.BuildResult, config *types.Buil
 	}
 
 	if config.Push && config.Registry != "" {
-		if err := e.pushMultiArchImage(index, config, imageDir); err != nil {
-			return fmt.Errorf("failed to push multi-arch image: %v", err)
+		if err := checkCancelled(workDir); err != nil {
+			return err
 		}
-	}
-
-	return nil
-}
-
-func (e *MultiArchExporter) buildPlatformManifest(platform types.Platform, platformResult *types.PlatformResult, config *types.BuildConfig, workDir string) (*OCIManifest, error) {
-	layersDir := filepath.Join(workDir, "layers", platform.String())
-	
-	layers, err := e.collectPlatformLayers(layersDir, platform)
-	if err != nil {
-		return nil, fmt.Errorf("failed to collect layers for %s: %v", platform.String(), err)
-	}
-
-	imageConfig := &OCIImageConfig{
-		Created:      time.Now(),
-		Architecture: platform.Architecture,
-		OS:           platform.OS,
-		Config:       e.buildContainerConfig(config, platform),
-		RootFS: OCIRootFS{
-			Type:    "layers",
-			DiffIDs: layers,
-		},
-		History: e.buildPlatformHistory(platform),
-	}
-
-	if platform.Variant != "" {
-		imageConfig.Variant = platform.Variant
-	}
-
-	configData, err := json.Marshal(imageConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal image config: %v", err)
-	}
-
-	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(configData))
-	configPath := filepath.Join(workDir, "multiarch", "blobs", configDigest[7:]+".json")
-	
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		return nil, fmt.Errorf("failed to create config directory: %v", err)
-	}
-	
-	if err := os.WriteFile(configPath, configData, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write config: %v", err)
-	}
-
-	layerDescriptors := make([]OCIDescriptor, len(layers))
-	for i, layer := range layers {
-		layerDescriptors[i] = OCIDescriptor{
-			MediaType: "application/vnd.oci.image.layer.v1.tar",
-			Digest:    layer,
-			Size:      0, 
+		if err := e.pushMultiArchImage(layout, index, indexData, images, config); err != nil {
+			return fmt.Errorf("failed to push multi-arch image: %w", err)
 		}
-	}
 
-	manifest := &OCIManifest{
-		SchemaVersion: 2,
-		MediaType:     "application/vnd.oci.image.manifest.v1+json",
-		Config: OCIDescriptor{
-			MediaType: "application/vnd.oci.image.config.v1+json",
-			Digest:    configDigest,
-			Size:      int64(len(configData)),
-		},
-		Layers: layerDescriptors,
-		Annotations: map[string]string{
-			"org.opencontainers.image.created": time.Now().Format(time.RFC3339),
-			"org.opencontainers.image.platform": platform.String(),
-		},
-	}
-
-	return manifest, nil
-}
-
-func (e *MultiArchExporter) collectPlatformLayers(layersDir string, platform types.Platform) ([]string, error) {
-	var layers []string
-	
-	entries, err := os.ReadDir(layersDir)
-	if os.IsNotExist(err) {
-		return layers, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			layerHash := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(entry.Name()+platform.String())))
-			layers = append(layers, layerHash)
+		if err := e.pushPlatformTags(layout, images, config); err != nil {
+			return fmt.Errorf("failed to push per-platform tags: %w", err)
 		}
 	}
 
-	return layers, nil
+	return nil
 }
 
 func (e *MultiArchExporter) buildContainerConfig(config *types.BuildConfig, platform types.Platform) OCIContainerConfig {
@@ -258,36 +201,45 @@ func (e *MultiArchExporter) buildPlatformHistory(platform types.Platform) []OCIH
 	}
 }
 
-func (e *MultiArchExporter) pushMultiArchImage(index *OCIIndex, config *types.BuildConfig, imageDir string) error {
+// pushMultiArchImage pushes each platform image by digest and then the
+// index that lists them under every tag.
+func (e *MultiArchExporter) pushMultiArchImage(layout *OCILayout, index *OCIIndex, indexData []byte, images []*platformImage, config *types.BuildConfig) error {
 	if len(config.Tags) == 0 {
 		return fmt.Errorf("no tags specified for push")
 	}
 
 	for _, tag := range config.Tags {
-		if !strings.Contains(tag, config.Registry) {
-			tag = config.Registry + "/" + tag
+		ref := PushReference(tag, config.Registry)
+		name, _ := registry.SplitReference(ref)
+
+		for _, image := range images {
+			if _, err := pushImage(layout, image.manifest, image.data, name+"@"+image.descriptor.Digest); err != nil {
+				return fmt.Errorf("failed to push %s image for %s: %w", image.platform.String(), ref, err)
+			}
 		}
 
-		cmd := fmt.Sprintf("skopeo copy oci:%s:%s docker://%s", imageDir, "latest", tag)
-		
-		if err := e.runCommand(cmd); err != nil {
-			return fmt.Errorf("failed to push %s: %v", tag, err)
+		client, reference, err := registry.NewClient(ref)
+		if err != nil {
+			return err
+		}
+		if err := client.PushManifest(reference, index.MediaType, indexData); err != nil {
+			return fmt.Errorf("failed to push %s: %w", ref, err)
 		}
 	}
 
 	return nil
 }
 
-func (e *MultiArchExporter) runCommand(command string) error {
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return fmt.Errorf("empty command")
-	}
-
-	cmd := exec.Command(parts[0], parts[1:]...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("command failed: %v, output: %s", err, string(output))
+// pushPlatformTags pushes each platform's own manifest, the same one the
+// index points at, under its architecture-suffixed tags.
+func (e *MultiArchExporter) pushPlatformTags(layout *OCILayout, images []*platformImage, config *types.BuildConfig) error {
+	for _, image := range images {
+		for _, tag := range image.tags {
+			ref := PushReference(tag, config.Registry)
+			if _, err := pushImage(layout, image.manifest, image.data, ref); err != nil {
+				return fmt.Errorf("failed to push %s: %w", ref, err)
+			}
+		}
 	}
 
 	return nil
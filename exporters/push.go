@@ -0,0 +1,71 @@
+package exporters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/executors"
+	"github.com/bibin-skaria/ossb/internal/registry"
+)
+
+// checkCancelled keeps a build that was cancelled while exporting from
+// pushing anything.
+func checkCancelled(workDir string) error {
+	if executors.Cancelled(workDir) {
+		return fmt.Errorf("build cancelled")
+	}
+	return nil
+}
+
+// PushReference qualifies tag with the registry the same way the push
+// exporters always have: tags that don't already mention it get it
+// prepended.
+func PushReference(tag, registryHost string) string {
+	if registryHost != "" && !strings.Contains(tag, registryHost) {
+		return registryHost + "/" + tag
+	}
+	return tag
+}
+
+// pushImage uploads a manifest and the blobs it references from layout to
+// ref. Blobs the registry already has, for example layers streamed there
+// while the build was running, are skipped.
+func pushImage(layout *OCILayout, manifest *OCIManifest, manifestData []byte, ref string) (int, error) {
+	client, reference, err := registry.NewClient(ref)
+	if err != nil {
+		return 0, err
+	}
+
+	uploaded := 0
+	blobs := append([]OCIDescriptor{manifest.Config}, manifest.Layers...)
+	for _, blob := range blobs {
+		pushed, err := client.PushBlob(blob.Digest, layout.BlobPath(blob.Digest))
+		if err != nil {
+			return uploaded, err
+		}
+		if pushed {
+			uploaded++
+		}
+	}
+
+	if err := client.PushManifest(reference, manifest.MediaType, manifestData); err != nil {
+		return uploaded, err
+	}
+
+	return uploaded, nil
+}
+
+func pushTags(layout *OCILayout, manifest *OCIManifest, manifestData []byte, tags []string, registryHost string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("no tags specified for push")
+	}
+
+	for _, tag := range tags {
+		ref := PushReference(tag, registryHost)
+		if _, err := pushImage(layout, manifest, manifestData, ref); err != nil {
+			return fmt.Errorf("failed to push %s: %w", ref, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package exporters
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+const (
+	schemaImageConfig   = "config-schema.json"
+	schemaImageManifest = "image-manifest-schema.json"
+	schemaImageIndex    = "image-index-schema.json"
+)
+
+// jsonSchema is the subset of JSON Schema used by the OCI image-spec
+// schemas embedded under schema/.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []string               `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	MinLength            *int                   `json:"minLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+}
+
+type schemaValidator struct {
+	definitions map[string]*jsonSchema
+	errors      []string
+}
+
+// validateOCI checks a generated config, manifest or index document
+// against the embedded OCI image-spec schema so export fails with precise
+// field errors instead of producing a layout registries reject.
+func validateOCI(schemaName string, data []byte) error {
+	schema, definitions, err := loadSchema(schemaName)
+	if err != nil {
+		return err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	v := &schemaValidator{definitions: definitions}
+	v.validate("", schema, document)
+
+	if len(v.errors) > 0 {
+		return fmt.Errorf("%s", strings.Join(v.errors, "; "))
+	}
+	return nil
+}
+
+func loadSchema(name string) (*jsonSchema, map[string]*jsonSchema, error) {
+	definitions := make(map[string]*jsonSchema)
+
+	var defs jsonSchema
+	defsData, err := schemaFS.ReadFile("schema/defs-descriptor.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read schema definitions: %v", err)
+	}
+	if err := json.Unmarshal(defsData, &defs); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse schema definitions: %v", err)
+	}
+	for key, def := range defs.Definitions {
+		definitions[key] = def
+	}
+
+	data, err := schemaFS.ReadFile("schema/" + name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read schema %s: %v", name, err)
+	}
+
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse schema %s: %v", name, err)
+	}
+	for key, def := range schema.Definitions {
+		definitions[key] = def
+	}
+
+	return &schema, definitions, nil
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(path string, schema *jsonSchema, value interface{}) {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/definitions/")
+		def, exists := v.definitions[name]
+		if !exists {
+			v.fail(path, "unknown schema reference %s", schema.Ref)
+			return
+		}
+		v.validate(path, def, value)
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "expected object, got %s", jsonTypeName(value))
+			return
+		}
+		v.validateObject(path, schema, object)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "expected array, got %s", jsonTypeName(value))
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				v.validate(fmt.Sprintf("%s[%d]", path, i), schema.Items, item)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.fail(path, "expected string, got %s", jsonTypeName(value))
+			return
+		}
+		v.validateString(path, schema, str)
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			v.fail(path, "expected integer, got %s", jsonTypeName(value))
+			return
+		}
+		if schema.Minimum != nil && number < *schema.Minimum {
+			v.fail(path, "must be >= %v, got %v", *schema.Minimum, number)
+		}
+		if schema.Maximum != nil && number > *schema.Maximum {
+			v.fail(path, "must be <= %v, got %v", *schema.Maximum, number)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(path, "expected boolean, got %s", jsonTypeName(value))
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(path string, schema *jsonSchema, object map[string]interface{}) {
+	for _, key := range schema.Required {
+		if _, exists := object[key]; !exists {
+			v.fail(joinSchemaPath(path, key), "required field is missing")
+		}
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if propSchema, exists := schema.Properties[key]; exists {
+			v.validate(joinSchemaPath(path, key), propSchema, object[key])
+		} else if schema.AdditionalProperties != nil {
+			v.validate(joinSchemaPath(path, key), schema.AdditionalProperties, object[key])
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(path string, schema *jsonSchema, value string) {
+	if schema.MinLength != nil && len(value) < *schema.MinLength {
+		v.fail(path, "must not be empty")
+	}
+
+	if len(schema.Enum) > 0 {
+		allowed := false
+		for _, candidate := range schema.Enum {
+			if value == candidate {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			v.fail(path, "%q is not one of %s", value, strings.Join(schema.Enum, ", "))
+		}
+	}
+
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			v.fail(path, "invalid schema pattern %q: %v", schema.Pattern, err)
+		} else if !re.MatchString(value) {
+			v.fail(path, "%q does not match pattern %s", value, schema.Pattern)
+		}
+	}
+
+	if schema.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			v.fail(path, "%q is not an RFC 3339 date-time", value)
+		}
+	}
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
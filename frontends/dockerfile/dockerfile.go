@@ -1,12 +1,17 @@
 package dockerfile
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/bibin-skaria/ossb/frontends"
+	"github.com/bibin-skaria/ossb/internal/dockerignore"
+	"github.com/bibin-skaria/ossb/internal/ocilayout"
 	"github.com/bibin-skaria/ossb/internal/types"
 )
 
@@ -25,6 +30,20 @@ func (d *DockerfileFrontend) Parse(dockerfileContent string, config *types.Build
 		user:        "root",
 	}
 
+	if ignoreFile := dockerignore.ResolveFile(config.Context, config.Dockerfile); ignoreFile != "" {
+		patterns, err := dockerignore.ReadFile(ignoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", ignoreFile, err)
+		}
+		parser.ignorePatterns = patterns
+	}
+
+	matcher, err := dockerignore.New(parser.ignorePatterns)
+	if err != nil {
+		return nil, err
+	}
+	parser.ignoreMatcher = matcher
+
 	return parser.Parse(dockerfileContent)
 }
 
@@ -35,6 +54,9 @@ type Parser struct {
 	workdir     string
 	user        string
 	operations  []*types.Operation
+
+	ignorePatterns []string
+	ignoreMatcher  *dockerignore.Matcher
 }
 
 func (p *Parser) Parse(content string) ([]*types.Operation, error) {
@@ -164,11 +186,116 @@ func (p *Parser) processFrom(instruction *types.DockerfileInstruction) error {
 	if alias != "" {
 		op.Metadata["alias"] = alias
 	}
+
+	if err := p.resolveLocalSource(image, op.Metadata); err != nil {
+		return err
+	}
 	
 	p.operations = append(p.operations, op)
 	return nil
 }
 
+func (p *Parser) contextPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.config.Context, path)
+}
+
+var layoutDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// resolveLocalSource fills in the metadata for FROM dir:// and
+// FROM oci-layout:// references, resolving relative paths against the build
+// context. Other images are left alone. The metadata also pins what the
+// reference points at now, the image's manifest digest or a fingerprint of
+// the directory, so the step's cache key changes when the source does.
+func (p *Parser) resolveLocalSource(image string, metadata map[string]string) error {
+	var source, path, ref string
+	switch {
+	case strings.HasPrefix(image, types.SourceDir+"://"):
+		source, path = types.SourceDir, strings.TrimPrefix(image, types.SourceDir+"://")
+	case strings.HasPrefix(image, types.SourceOCILayout+"://"):
+		source, path = types.SourceOCILayout, strings.TrimPrefix(image, types.SourceOCILayout+"://")
+		if idx := strings.Index(path, "@"); idx >= 0 {
+			path, ref = path[:idx], path[idx+1:]
+			if !layoutDigestPattern.MatchString(ref) {
+				return fmt.Errorf("invalid digest in %s: %s", image, ref)
+			}
+		} else {
+			// Tags may contain ":" themselves ("app:1.0"), so split at
+			// the first ":" that leaves an existing directory.
+			for idx := strings.Index(path, ":"); idx >= 0; {
+				if info, err := os.Stat(p.contextPath(path[:idx])); err == nil && info.IsDir() {
+					path, ref = path[:idx], path[idx+1:]
+					break
+				}
+				next := strings.Index(path[idx+1:], ":")
+				if next < 0 {
+					break
+				}
+				idx += next + 1
+			}
+		}
+	default:
+		return nil
+	}
+
+	if path == "" {
+		return fmt.Errorf("FROM %s requires a path", image)
+	}
+	path = p.contextPath(path)
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("FROM %s: %s is not a directory", image, path)
+	}
+
+	metadata["source"] = source
+	metadata["path"] = path
+	if ref != "" {
+		metadata["ref"] = ref
+	}
+
+	switch source {
+	case types.SourceOCILayout:
+		platform := types.GetHostPlatform()
+		if len(p.config.Platforms) > 0 {
+			platform = p.config.Platforms[0]
+		}
+		digest, err := ocilayout.ResolveDigest(path, ref, platform)
+		if err != nil {
+			return fmt.Errorf("FROM %s: %v", image, err)
+		}
+		metadata["digest"] = digest
+	case types.SourceDir:
+		fingerprint, err := dirFingerprint(path)
+		if err != nil {
+			return fmt.Errorf("FROM %s: %v", image, err)
+		}
+		metadata["fingerprint"] = fingerprint
+	}
+	return nil
+}
+
+// dirFingerprint summarizes a directory tree by the path, mode, size and
+// modification time of everything in it, which is enough to notice a
+// changed root filesystem without reading every file.
+func dirFingerprint(dir string) (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(hash, "%s %o %d %d\n", filepath.ToSlash(rel), info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
 func (p *Parser) processRun(instruction *types.DockerfileInstruction) error {
 	value := p.expandVariables(instruction.Value)
 	command := p.parseCommand(value)
@@ -222,11 +349,65 @@ func (p *Parser) processFileOperation(instruction *types.DockerfileInstruction,
 			"dest": dest,
 		},
 	}
+
+	// Executors apply the ignore patterns while copying. The step is
+	// cached on the patterns and a digest of the files it copies, not on
+	// where the context is.
+	if len(p.ignorePatterns) > 0 {
+		op.Metadata["dockerignore"] = strings.Join(p.ignorePatterns, "\n")
+	}
+	digest, err := p.contentDigest(sources)
+	if err != nil {
+		return err
+	}
+	op.Context = p.config.Context
+	op.Metadata["content"] = digest
 	
 	p.operations = append(p.operations, op)
 	return nil
 }
 
+// contentDigests remembers COPY and ADD content digests across Parse
+// calls. A multi-platform build parses the Dockerfile once per platform
+// against the same context, so the files are read and hashed once rather
+// than once per platform. An entry is reused only while its stat digest
+// (paths, sizes and modification times) is unchanged, which keeps later
+// builds in the same process from picking up a stale digest.
+var contentDigests = struct {
+	sync.Mutex
+	entries map[string]contentDigest
+}{entries: make(map[string]contentDigest)}
+
+type contentDigest struct {
+	stat   string
+	digest string
+}
+
+func (p *Parser) contentDigest(sources []string) (string, error) {
+	key := strings.Join(append([]string{p.config.Context, strings.Join(p.ignorePatterns, "\n")}, sources...), "\x00")
+	stat, err := p.ignoreMatcher.StatDigest(p.config.Context, sources)
+	if err != nil {
+		return "", err
+	}
+
+	contentDigests.Lock()
+	cached, exists := contentDigests.entries[key]
+	contentDigests.Unlock()
+	if exists && cached.stat == stat {
+		return cached.digest, nil
+	}
+
+	digest, err := p.ignoreMatcher.Digest(p.config.Context, sources)
+	if err != nil {
+		return "", err
+	}
+
+	contentDigests.Lock()
+	contentDigests.entries[key] = contentDigest{stat: stat, digest: digest}
+	contentDigests.Unlock()
+	return digest, nil
+}
+
 func (p *Parser) processWorkdir(instruction *types.DockerfileInstruction) error {
 	workdir := p.expandVariables(instruction.Value)
 	
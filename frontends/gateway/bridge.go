@@ -0,0 +1,502 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/bibin-skaria/ossb/internal/registry"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+const bridgeService = "/moby.buildkit.v1.frontend.LLBBridge/"
+
+// gRPC status codes the bridge answers with.
+const (
+	codeUnknown         = 2
+	codeInvalidArgument = 3
+	codeNotFound        = 5
+	codeUnimplemented   = 12
+)
+
+// Capabilities advertised to frontends. Frontends check them before using
+// a feature, so anything left out fails with a clear "unsupported" error
+// in the frontend rather than halfway through the build.
+var (
+	frontendCaps = []string{
+		"solve.base", "resolveimage", "resolveimage.resolvemode", "readfile", "return", "returnmap",
+		"proto.refarray", "reference.output",
+	}
+	llbCaps = []string{
+		"source.image", "source.local", "source.local.unique", "source.local.sessionid",
+		"source.local.includepatterns", "source.local.followpaths", "source.local.excludepatterns",
+		"source.local.sharedkeyhint", "exec.meta.base", "exec.mount.bind", "exec.mount.selector",
+		"file.base", "constraints", "platform", "meta.ignorecache", "meta.description",
+	}
+)
+
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// frontendResult is what the frontend handed to Return.
+type frontendResult struct {
+	definitions map[string][]byte
+	metadata    map[string][]byte
+	err         error
+}
+
+// resolveImage fetches an image's digest and config from its registry.
+var resolveImage = registry.ResolveImageConfig
+
+// bridge serves the subset of BuildKit's LLBBridge gRPC service a frontend
+// needs to read the Dockerfile, look up base images and return a
+// definition: Ping, ResolveImageConfig, Solve, ReadFile and Return. Solve
+// only records definitions; nothing is built until the frontend returns
+// and its LLB is translated into operations.
+type bridge struct {
+	locals map[string]string
+
+	mu          sync.Mutex
+	definitions map[string][]byte
+	result      *frontendResult
+	returned    chan struct{}
+}
+
+func newBridge(locals map[string]string) *bridge {
+	return &bridge{
+		locals:      locals,
+		definitions: make(map[string][]byte),
+		returned:    make(chan struct{}),
+	}
+}
+
+// ServeHTTP handles one unary gRPC call.
+func (b *bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	response, err := b.call(r)
+	if err == nil {
+		frame := make([]byte, 5, 5+len(response))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(frame, response...))
+		w.Header().Set("Grpc-Status", "0")
+		return
+	}
+
+	code := codeUnknown
+	if status, ok := err.(*statusError); ok {
+		code = status.code
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Grpc-Status", fmt.Sprintf("%d", code))
+	w.Header().Set("Grpc-Message", encodeGrpcMessage(err.Error()))
+}
+
+func (b *bridge) call(r *http.Request) ([]byte, error) {
+	method, found := strings.CutPrefix(r.URL.Path, bridgeService)
+	if !found {
+		return nil, errorf(codeUnimplemented, "unknown service %s", r.URL.Path)
+	}
+
+	var header [5]byte
+	if _, err := io.ReadFull(r.Body, header[:]); err != nil {
+		return nil, errorf(codeInvalidArgument, "failed to read request: %v", err)
+	}
+	if header[0] != 0 {
+		return nil, errorf(codeUnimplemented, "compressed requests are not supported")
+	}
+	request := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r.Body, request); err != nil {
+		return nil, errorf(codeInvalidArgument, "failed to read request: %v", err)
+	}
+
+	fields, err := decodeFields(request)
+	if err != nil {
+		return nil, errorf(codeInvalidArgument, "invalid %s request: %v", method, err)
+	}
+
+	switch method {
+	case "Ping":
+		return b.ping(), nil
+	case "ResolveImageConfig":
+		return b.resolveImageConfig(fields)
+	case "Solve":
+		return b.solve(fields)
+	case "ReadFile":
+		return b.readFile(fields)
+	case "Return":
+		return b.setResult(fields)
+	default:
+		return nil, errorf(codeUnimplemented, "%s is not supported by the OSSB gateway", method)
+	}
+}
+
+func (b *bridge) ping() []byte {
+	var response []byte
+	for _, list := range []struct {
+		num  protowire.Number
+		caps []string
+	}{{1, frontendCaps}, {2, llbCaps}} {
+		for _, id := range list.caps {
+			var apiCap []byte
+			apiCap = protowire.AppendTag(apiCap, 1, protowire.BytesType)
+			apiCap = protowire.AppendString(apiCap, id)
+			apiCap = protowire.AppendTag(apiCap, 2, protowire.VarintType)
+			apiCap = protowire.AppendVarint(apiCap, 1)
+
+			response = protowire.AppendTag(response, list.num, protowire.BytesType)
+			response = protowire.AppendBytes(response, apiCap)
+		}
+	}
+	return response
+}
+
+// resolveImageConfig looks the image up in its registry and answers with
+// the digest its reference resolves to and its config for the requested
+// platform, which frontends use to inherit ENV, WORKDIR and the like.
+func (b *bridge) resolveImageConfig(fields []field) ([]byte, error) {
+	ref := ""
+	platform := types.GetHostPlatform()
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			ref = f.string()
+		case 2:
+			platformFields, err := decodeFields(f.bytes)
+			if err != nil {
+				return nil, errorf(codeInvalidArgument, "invalid platform: %v", err)
+			}
+			for _, pf := range platformFields {
+				switch pf.num {
+				case 1:
+					platform.Architecture = pf.string()
+				case 2:
+					platform.OS = pf.string()
+				case 3:
+					platform.Variant = pf.string()
+				}
+			}
+		}
+	}
+	if ref == "" {
+		return nil, errorf(codeInvalidArgument, "no image reference to resolve")
+	}
+
+	digest, config, err := resolveImage(ref, platform)
+	if err != nil {
+		return nil, errorf(codeNotFound, "failed to resolve %s: %v", ref, err)
+	}
+
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.BytesType)
+	response = protowire.AppendString(response, digest)
+	response = protowire.AppendTag(response, 2, protowire.BytesType)
+	response = protowire.AppendBytes(response, config)
+	return response, nil
+}
+
+// solve records the definition under a new reference so the frontend can
+// read files from it or return it.
+func (b *bridge) solve(fields []field) ([]byte, error) {
+	var definition []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			definition = f.bytes
+		case 2:
+			if f.string() != "" {
+				return nil, errorf(codeUnimplemented, "solving with another frontend (%s) is not supported", f.string())
+			}
+		}
+	}
+	if _, err := decodeDefinition(definition); err != nil {
+		return nil, errorf(codeInvalidArgument, "%v", err)
+	}
+
+	b.mu.Lock()
+	id := fmt.Sprintf("ref-%d", len(b.definitions)+1)
+	b.definitions[id] = definition
+	b.mu.Unlock()
+
+	var ref []byte
+	ref = protowire.AppendTag(ref, 1, protowire.BytesType)
+	ref = protowire.AppendString(ref, id)
+	ref = protowire.AppendTag(ref, 2, protowire.BytesType)
+	ref = protowire.AppendBytes(ref, definition)
+
+	var result []byte
+	result = protowire.AppendTag(result, 3, protowire.BytesType)
+	result = protowire.AppendBytes(result, ref)
+
+	var response []byte
+	response = protowire.AppendTag(response, 3, protowire.BytesType)
+	response = protowire.AppendBytes(response, result)
+	return response, nil
+}
+
+// readFile serves files from local sources, which is how frontends read
+// the Dockerfile. Built states have no filesystem to read from until the
+// build runs.
+func (b *bridge) readFile(fields []field) ([]byte, error) {
+	var id, path string
+	var offset, length int64
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			id = f.string()
+		case 2:
+			path = f.string()
+		case 3:
+			rangeFields, err := decodeFields(f.bytes)
+			if err != nil {
+				return nil, errorf(codeInvalidArgument, "invalid file range: %v", err)
+			}
+			for _, rf := range rangeFields {
+				switch rf.num {
+				case 1:
+					offset = rf.int()
+				case 2:
+					length = rf.int()
+				}
+			}
+		}
+	}
+
+	b.mu.Lock()
+	definition, exists := b.definitions[id]
+	b.mu.Unlock()
+	if !exists {
+		return nil, errorf(codeNotFound, "unknown reference %q", id)
+	}
+
+	def, err := decodeDefinition(definition)
+	if err != nil {
+		return nil, errorf(codeInvalidArgument, "%v", err)
+	}
+	dir, err := b.localDir(def)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(localPath(dir, path))
+	if err != nil {
+		return nil, errorf(codeNotFound, "failed to read %s: %v", path, err)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length > 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.BytesType)
+	response = protowire.AppendBytes(response, data)
+	return response, nil
+}
+
+func (b *bridge) localDir(def *llbDefinition) (string, error) {
+	op, exists := def.ops[def.final.digest]
+	if !exists {
+		return "", errorf(codeInvalidArgument, "LLB references unknown vertex %s", def.final.digest)
+	}
+
+	t := &translator{def: def, locals: b.locals}
+	dir, isLocal, err := t.local(op)
+	if err != nil {
+		return "", errorf(codeNotFound, "%v", err)
+	}
+	if !isLocal {
+		return "", errorf(codeUnimplemented, "reading files from a %s result is not supported", op.kind)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// setResult records what the frontend returned: definitions keyed by
+// platform ("" for a single result), image metadata, or an error.
+func (b *bridge) setResult(fields []field) ([]byte, error) {
+	result := &frontendResult{
+		definitions: make(map[string][]byte),
+		metadata:    make(map[string][]byte),
+	}
+
+	for _, f := range fields {
+		var err error
+		switch f.num {
+		case 1:
+			err = b.decodeResult(f.bytes, result)
+		case 2:
+			result.err = decodeStatus(f.bytes)
+		}
+		if err != nil {
+			return nil, errorf(codeInvalidArgument, "invalid result: %v", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.result != nil {
+		return nil, errorf(codeInvalidArgument, "result already returned")
+	}
+	b.result = result
+	close(b.returned)
+
+	return nil, nil
+}
+
+func (b *bridge) decodeResult(data []byte, result *frontendResult) error {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			definition, err := b.refDefinition(f.string(), nil)
+			if err != nil {
+				return err
+			}
+			result.definitions[""] = definition
+		case 3:
+			definition, err := b.decodeRef(f.bytes)
+			if err != nil {
+				return err
+			}
+			result.definitions[""] = definition
+		case 4:
+			refs, err := decodeFields(f.bytes)
+			if err != nil {
+				return err
+			}
+			for _, entry := range refs {
+				key, value, err := decodeMapEntry(entry.bytes)
+				if err != nil {
+					return err
+				}
+				definition, err := b.decodeRef(value)
+				if err != nil {
+					return err
+				}
+				result.definitions[key] = definition
+			}
+		case 10:
+			key, value, err := decodeMapEntry(f.bytes)
+			if err != nil {
+				return err
+			}
+			result.metadata[key] = value
+		}
+	}
+	return nil
+}
+
+func (b *bridge) decodeRef(data []byte) ([]byte, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	var definition []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			id = f.string()
+		case 2:
+			definition = f.bytes
+		}
+	}
+	return b.refDefinition(id, definition)
+}
+
+// refDefinition returns the definition a reference carries, or the one
+// solved under its ID.
+func (b *bridge) refDefinition(id string, definition []byte) ([]byte, error) {
+	if len(definition) > 0 {
+		return definition, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	definition, exists := b.definitions[id]
+	if !exists {
+		return nil, fmt.Errorf("unknown reference %q", id)
+	}
+	return definition, nil
+}
+
+func decodeStatus(data []byte) error {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return fmt.Errorf("frontend failed with an unreadable error")
+	}
+
+	code, message := int64(0), ""
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			code = f.int()
+		case 2:
+			message = f.string()
+		}
+	}
+	if code == 0 && message == "" {
+		return nil
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// encodeGrpcMessage percent-encodes a status message as gRPC requires.
+func encodeGrpcMessage(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// stdioConn is the frontend's end of the connection: gRPC requests arrive
+// on its stdout and responses go to its stdin.
+type stdioConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (c *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (c *stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "frontend" }
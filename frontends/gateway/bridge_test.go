@@ -0,0 +1,291 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// bridgeClient makes unary gRPC calls to a bridge over an in-memory
+// HTTP/2 connection, the way a frontend does over its stdio.
+type bridgeClient struct {
+	t    *testing.T
+	conn *http2.ClientConn
+}
+
+func newBridgeClient(t *testing.T, b *bridge) *bridgeClient {
+	serverEnd, clientEnd := net.Pipe()
+	go (&http2.Server{}).ServeConn(serverEnd, &http2.ServeConnOpts{Handler: b})
+
+	conn, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(clientEnd)
+	if err != nil {
+		t.Fatalf("failed to connect to the bridge: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		serverEnd.Close()
+	})
+	return &bridgeClient{t: t, conn: conn}
+}
+
+// call sends request to method and returns the response message and the
+// gRPC status code.
+func (c *bridgeClient) call(method string, request []byte) ([]byte, string) {
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+
+	req, err := http.NewRequest(http.MethodPost, "http://frontend"+bridgeService+method, bytes.NewReader(append(frame, request...)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.conn.RoundTrip(req)
+	if err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("%s: failed to read response: %v", method, err)
+	}
+
+	status := resp.Trailer.Get("Grpc-Status")
+	if status != "0" {
+		return nil, status
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		c.t.Fatalf("%s: malformed response frame %x", method, body)
+	}
+	return body[5:], status
+}
+
+func appendBytesField(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func appendStringField(b []byte, num protowire.Number, value string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// localDefinition builds the LLB a frontend solves to read its build
+// context: a local source and the terminator pointing at it.
+func localDefinition(name string) []byte {
+	var source []byte
+	source = appendStringField(source, 1, "local://"+name)
+	var op []byte
+	op = appendBytesField(op, 3, source)
+
+	var input []byte
+	input = appendStringField(input, 1, fmt.Sprintf("sha256:%x", sha256.Sum256(op)))
+	input = protowire.AppendTag(input, 2, protowire.VarintType)
+	input = protowire.AppendVarint(input, 0)
+	var terminator []byte
+	terminator = appendBytesField(terminator, 1, input)
+
+	var definition []byte
+	definition = appendBytesField(definition, 1, op)
+	definition = appendBytesField(definition, 1, terminator)
+	return definition
+}
+
+// findField follows nums through nested messages and returns the first
+// field found at the end of the path.
+func findField(t *testing.T, message []byte, nums ...protowire.Number) field {
+	t.Helper()
+	var found field
+	for i, num := range nums {
+		fields, err := decodeFields(message)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		ok := false
+		for _, f := range fields {
+			if f.num == num {
+				found, ok = f, true
+				break
+			}
+		}
+		if !ok {
+			t.Fatalf("response has no field %v", nums[:i+1])
+		}
+		message = found.bytes
+	}
+	return found
+}
+
+func TestBridgeSolveReadFileReturn(t *testing.T) {
+	contextDir := t.TempDir()
+	dockerfile := "FROM alpine\nRUN echo hello\n"
+	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBridge(map[string]string{"dockerfile": contextDir})
+	client := newBridgeClient(t, b)
+	definition := localDefinition("dockerfile")
+
+	// Solve: the definition comes back under a reference (result.ref.id).
+	response, status := client.call("Solve", appendBytesField(nil, 1, definition))
+	if status != "0" {
+		t.Fatalf("Solve failed with status %s", status)
+	}
+	refID := findField(t, response, 3, 3, 1).string()
+	if refID == "" {
+		t.Fatal("Solve returned no reference")
+	}
+
+	// ReadFile: the whole file, then a range of it.
+	var readFile []byte
+	readFile = appendStringField(readFile, 1, refID)
+	readFile = appendStringField(readFile, 2, "/Dockerfile")
+	response, status = client.call("ReadFile", readFile)
+	if status != "0" {
+		t.Fatalf("ReadFile failed with status %s", status)
+	}
+	if got := findField(t, response, 1).string(); got != dockerfile {
+		t.Fatalf("ReadFile returned %q, want %q", got, dockerfile)
+	}
+
+	var fileRange []byte
+	fileRange = protowire.AppendTag(fileRange, 1, protowire.VarintType)
+	fileRange = protowire.AppendVarint(fileRange, 5)
+	fileRange = protowire.AppendTag(fileRange, 2, protowire.VarintType)
+	fileRange = protowire.AppendVarint(fileRange, 6)
+	response, status = client.call("ReadFile", appendBytesField(readFile, 3, fileRange))
+	if status != "0" {
+		t.Fatalf("ReadFile with a range failed with status %s", status)
+	}
+	if got := findField(t, response, 1).string(); got != "alpine" {
+		t.Fatalf("ReadFile with a range returned %q, want %q", got, "alpine")
+	}
+
+	// Unknown references and missing files are NotFound.
+	var unknown []byte
+	unknown = appendStringField(unknown, 1, "ref-99")
+	unknown = appendStringField(unknown, 2, "Dockerfile")
+	if _, status := client.call("ReadFile", unknown); status != fmt.Sprint(codeNotFound) {
+		t.Fatalf("ReadFile of an unknown reference returned status %s, want %d", status, codeNotFound)
+	}
+	var missing []byte
+	missing = appendStringField(missing, 1, refID)
+	missing = appendStringField(missing, 2, "missing")
+	if _, status := client.call("ReadFile", missing); status != fmt.Sprint(codeNotFound) {
+		t.Fatalf("ReadFile of a missing file returned status %s, want %d", status, codeNotFound)
+	}
+
+	// Return: the definition is recorded under the reference's ID.
+	var ref []byte
+	ref = appendStringField(ref, 1, refID)
+	var result []byte
+	result = appendBytesField(result, 3, ref)
+	if _, status := client.call("Return", appendBytesField(nil, 1, result)); status != "0" {
+		t.Fatalf("Return failed with status %s", status)
+	}
+
+	select {
+	case <-b.returned:
+	default:
+		t.Fatal("Return did not record a result")
+	}
+	if b.result.err != nil {
+		t.Fatalf("Return recorded an error: %v", b.result.err)
+	}
+	if !bytes.Equal(b.result.definitions[""], definition) {
+		t.Fatal("Return recorded a different definition from the one solved")
+	}
+
+	if _, status := client.call("Return", appendBytesField(nil, 1, result)); status != fmt.Sprint(codeInvalidArgument) {
+		t.Fatalf("second Return returned status %s, want %d", status, codeInvalidArgument)
+	}
+}
+
+func TestBridgeReturnError(t *testing.T) {
+	b := newBridge(nil)
+	client := newBridgeClient(t, b)
+
+	var rpcStatus []byte
+	rpcStatus = protowire.AppendTag(rpcStatus, 1, protowire.VarintType)
+	rpcStatus = protowire.AppendVarint(rpcStatus, 3)
+	rpcStatus = appendStringField(rpcStatus, 2, "dockerfile parse error")
+	if _, status := client.call("Return", appendBytesField(nil, 2, rpcStatus)); status != "0" {
+		t.Fatalf("Return failed with status %s", status)
+	}
+
+	<-b.returned
+	if b.result.err == nil || b.result.err.Error() != "dockerfile parse error" {
+		t.Fatalf("Return recorded error %v, want the frontend's", b.result.err)
+	}
+}
+
+func TestBridgeUnsupportedMethods(t *testing.T) {
+	client := newBridgeClient(t, newBridge(nil))
+
+	if _, status := client.call("StatFile", nil); status != fmt.Sprint(codeUnimplemented) {
+		t.Fatalf("StatFile returned status %s, want %d", status, codeUnimplemented)
+	}
+
+	var solve []byte
+	solve = appendBytesField(solve, 1, localDefinition("context"))
+	solve = appendStringField(solve, 2, "dockerfile.v0")
+	if _, status := client.call("Solve", solve); status != fmt.Sprint(codeUnimplemented) {
+		t.Fatalf("Solve with a nested frontend returned status %s, want %d", status, codeUnimplemented)
+	}
+}
+
+func TestBridgeResolveImageConfig(t *testing.T) {
+	saved := resolveImage
+	defer func() { resolveImage = saved }()
+
+	var resolvedRef string
+	var resolvedPlatform types.Platform
+	resolveImage = func(ref string, platform types.Platform) (string, []byte, error) {
+		resolvedRef, resolvedPlatform = ref, platform
+		return "sha256:abc", []byte(`{"config":{"Env":["PATH=/bin"]}}`), nil
+	}
+
+	client := newBridgeClient(t, newBridge(nil))
+
+	var platform []byte
+	platform = appendStringField(platform, 1, "arm64")
+	platform = appendStringField(platform, 2, "linux")
+	var request []byte
+	request = appendStringField(request, 1, "docker.io/library/alpine:3.19")
+	request = appendBytesField(request, 2, platform)
+
+	response, status := client.call("ResolveImageConfig", request)
+	if status != "0" {
+		t.Fatalf("ResolveImageConfig failed with status %s", status)
+	}
+	if resolvedRef != "docker.io/library/alpine:3.19" || resolvedPlatform.Architecture != "arm64" || resolvedPlatform.OS != "linux" {
+		t.Fatalf("resolved %s for %s, want alpine for linux/arm64", resolvedRef, resolvedPlatform.String())
+	}
+	if got := findField(t, response, 1).string(); got != "sha256:abc" {
+		t.Fatalf("ResolveImageConfig returned digest %q", got)
+	}
+	if got := findField(t, response, 2).string(); got != `{"config":{"Env":["PATH=/bin"]}}` {
+		t.Fatalf("ResolveImageConfig returned config %q", got)
+	}
+
+	resolveImage = func(string, types.Platform) (string, []byte, error) {
+		return "", nil, fmt.Errorf("manifest unknown")
+	}
+	if _, status := client.call("ResolveImageConfig", request); status != fmt.Sprint(codeNotFound) {
+		t.Fatalf("failed ResolveImageConfig returned status %s, want %d", status, codeNotFound)
+	}
+}
@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http2"
+
+	"github.com/bibin-skaria/ossb/frontends"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// syntaxArg selects the frontend when the Dockerfile has no syntax
+// directive, as with BuildKit.
+const syntaxArg = "BUILDKIT_SYNTAX"
+
+// GatewayFrontend runs a BuildKit gateway frontend (such as
+// docker/dockerfile) and translates the LLB it returns into operations.
+// The frontend is a container image run with docker or podman, or a local
+// executable, and talks gRPC over its stdin and stdout. Only LLB that maps
+// onto a single chain of steps is supported; see translator.
+type GatewayFrontend struct{}
+
+func init() {
+	frontends.RegisterFrontend("gateway", &GatewayFrontend{})
+}
+
+func (g *GatewayFrontend) Parse(dockerfileContent string, config *types.BuildConfig) ([]*types.Operation, error) {
+	ref := syntaxDirective(dockerfileContent)
+	if ref == "" {
+		ref = config.BuildArgs[syntaxArg]
+	}
+	if ref == "" {
+		return nil, fmt.Errorf("the gateway frontend needs a \"# syntax=\" directive or a %s build arg", syntaxArg)
+	}
+
+	locals := map[string]string{
+		"context":    config.Context,
+		"dockerfile": filepath.Dir(filepath.Join(config.Context, config.Dockerfile)),
+	}
+
+	b := newBridge(locals)
+	if err := runFrontend(ref, frontendEnv(config), b); err != nil {
+		return nil, err
+	}
+
+	result := b.result
+	if result.err != nil {
+		return nil, fmt.Errorf("frontend %s failed: %v", ref, result.err)
+	}
+
+	platform := platformKey(config)
+	definition, exists := result.definitions[platform]
+	if !exists {
+		definition, exists = result.definitions[""]
+	}
+	if !exists {
+		return nil, fmt.Errorf("frontend %s returned no result for %s", ref, platform)
+	}
+
+	imageConfig, exists := result.metadata["containerimage.config/"+platform]
+	if !exists {
+		imageConfig = result.metadata["containerimage.config"]
+	}
+
+	def, err := decodeDefinition(definition)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := translate(def, locals, imageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("frontend %s: %v", ref, err)
+	}
+	return operations, nil
+}
+
+// runFrontend starts the frontend, serves the bridge on its stdio until it
+// exits, and checks that it returned a result.
+func runFrontend(ref string, env []string, b *bridge) error {
+	var cmd *exec.Cmd
+	if filepath.IsAbs(ref) {
+		cmd = exec.Command(ref)
+		cmd.Env = append(os.Environ(), env...)
+	} else {
+		runtime := "docker"
+		if _, err := exec.LookPath("podman"); err == nil && os.Getenv("RUNTIME") == "podman" {
+			runtime = "podman"
+		}
+		args := []string{"run", "--rm", "-i", "--network", "none"}
+		for _, entry := range env {
+			args = append(args, "-e", entry)
+		}
+		cmd = exec.Command(runtime, append(args, ref)...)
+	}
+
+	requests, responses := io.Pipe()
+	cmd.Stdout = responses
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start frontend %s: %v", ref, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start frontend %s: %v", ref, err)
+	}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server := &http2.Server{}
+		server.ServeConn(&stdioConn{Reader: requests, WriteCloser: stdin}, &http2.ServeConnOpts{Handler: b})
+	}()
+
+	waitErr := cmd.Wait()
+	responses.Close()
+	<-served
+
+	b.mu.Lock()
+	returned := b.result != nil
+	b.mu.Unlock()
+	if returned {
+		return nil
+	}
+
+	if waitErr == nil {
+		waitErr = fmt.Errorf("exited without returning a result")
+	}
+	if output := strings.TrimSpace(stderr.String()); output != "" {
+		return fmt.Errorf("frontend %s failed: %v: %s", ref, waitErr, output)
+	}
+	return fmt.Errorf("frontend %s failed: %v", ref, waitErr)
+}
+
+// frontendEnv passes the build options the way buildkitd does: each one as
+// a numbered BUILDKIT_FRONTEND_OPT variable.
+func frontendEnv(config *types.BuildConfig) []string {
+	opts := []string{
+		"filename=" + filepath.Base(config.Dockerfile),
+		"platform=" + platformKey(config),
+	}
+
+	var keys []string
+	for key := range config.BuildArgs {
+		if key != syntaxArg {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		opts = append(opts, fmt.Sprintf("build-arg:%s=%s", key, config.BuildArgs[key]))
+	}
+
+	var env []string
+	for i, opt := range opts {
+		env = append(env, fmt.Sprintf("BUILDKIT_FRONTEND_OPT_%d=%s", i, opt))
+	}
+
+	host := types.GetHostPlatform()
+	workers, _ := json.Marshal([]map[string]interface{}{{
+		"id": "ossb",
+		"platforms": []map[string]string{{
+			"os":           host.OS,
+			"architecture": host.Architecture,
+			"variant":      host.Variant,
+		}},
+	}})
+
+	return append(env,
+		"BUILDKIT_SESSION_ID=ossb",
+		"BUILDKIT_EXPORTEDPRODUCT=ossb",
+		"BUILDKIT_WORKERS="+string(workers),
+	)
+}
+
+// platformKey is the platform the frontend builds for; the builder parses
+// once per platform.
+func platformKey(config *types.BuildConfig) string {
+	if len(config.Platforms) > 0 {
+		return config.Platforms[0].String()
+	}
+	return types.GetHostPlatform().String()
+}
+
+// syntaxDirective returns the frontend named by a "# syntax=" parser
+// directive. Directives must come before any other line.
+func syntaxDirective(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			return ""
+		}
+		key, value, found := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), "=")
+		if !found {
+			return ""
+		}
+		if strings.EqualFold(strings.TrimSpace(key), "syntax") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
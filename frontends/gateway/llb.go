@@ -0,0 +1,331 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The LLB and gateway messages are decoded by hand: only the handful of
+// fields the bridge and the translation use are read, which keeps the
+// gateway free of BuildKit's generated protobuf packages. Field numbers
+// follow solver/pb/ops.proto and frontend/gateway/pb/gateway.proto.
+
+type field struct {
+	num    protowire.Number
+	varint uint64
+	bytes  []byte
+}
+
+func (f field) string() string {
+	return string(f.bytes)
+}
+
+func (f field) int() int64 {
+	return int64(f.varint)
+}
+
+// decodeFields splits a protobuf message into its fields, in wire order.
+// Fixed-width fields are skipped; nothing used here has any.
+func decodeFields(data []byte) ([]field, error) {
+	var fields []field
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// decodeMapEntry reads a map<string, ...> entry, returning its key and the
+// raw value.
+func decodeMapEntry(data []byte) (string, []byte, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var key string
+	var value []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			key = f.string()
+		case 2:
+			value = f.bytes
+		}
+	}
+	return key, value, nil
+}
+
+type llbInput struct {
+	digest string
+	index  int64
+}
+
+type llbMount struct {
+	input     int64
+	dest      string
+	output    int64
+	readonly  bool
+	mountType int64
+}
+
+type llbExec struct {
+	args   []string
+	env    []string
+	cwd    string
+	user   string
+	mounts []llbMount
+}
+
+type llbFileAction struct {
+	input          int64
+	secondaryInput int64
+	output         int64
+	kind           string
+	src            string
+	dest           string
+	path           string
+	makeParents    bool
+}
+
+type llbOp struct {
+	inputs     []llbInput
+	exec       *llbExec
+	identifier string
+	file       []llbFileAction
+	kind       string
+}
+
+// llbDefinition is a decoded pb.Definition: its ops keyed by digest, and
+// the vertex the definition evaluates to.
+type llbDefinition struct {
+	ops   map[string]*llbOp
+	final llbInput
+}
+
+func decodeDefinition(data []byte) (*llbDefinition, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LLB definition: %v", err)
+	}
+
+	def := &llbDefinition{ops: make(map[string]*llbOp)}
+	var last *llbOp
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		op, err := decodeOp(f.bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLB op: %v", err)
+		}
+		def.ops[fmt.Sprintf("sha256:%x", sha256.Sum256(f.bytes))] = op
+		last = op
+	}
+
+	// The last op is a terminator with no operation of its own that points
+	// at the definition's result.
+	if last == nil || last.kind != "" || len(last.inputs) != 1 {
+		return nil, fmt.Errorf("LLB definition has no result")
+	}
+	def.final = last.inputs[0]
+	return def, nil
+}
+
+func decodeOp(data []byte) (*llbOp, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &llbOp{}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			input, err := decodeInput(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			op.inputs = append(op.inputs, input)
+		case 2:
+			op.kind = "exec"
+			if op.exec, err = decodeExec(f.bytes); err != nil {
+				return nil, err
+			}
+		case 3:
+			op.kind = "source"
+			sourceFields, err := decodeFields(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			for _, sf := range sourceFields {
+				if sf.num == 1 {
+					op.identifier = sf.string()
+				}
+			}
+		case 4:
+			op.kind = "file"
+			if op.file, err = decodeFileOp(f.bytes); err != nil {
+				return nil, err
+			}
+		case 5:
+			op.kind = "build"
+		case 6:
+			op.kind = "merge"
+		case 7:
+			op.kind = "diff"
+		}
+	}
+	return op, nil
+}
+
+func decodeInput(data []byte) (llbInput, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return llbInput{}, err
+	}
+
+	var input llbInput
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			input.digest = f.string()
+		case 2:
+			input.index = f.int()
+		}
+	}
+	return input, nil
+}
+
+func decodeExec(data []byte) (*llbExec, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	exec := &llbExec{}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			metaFields, err := decodeFields(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			for _, mf := range metaFields {
+				switch mf.num {
+				case 1:
+					exec.args = append(exec.args, mf.string())
+				case 2:
+					exec.env = append(exec.env, mf.string())
+				case 3:
+					exec.cwd = mf.string()
+				case 4:
+					exec.user = mf.string()
+				}
+			}
+		case 2:
+			mountFields, err := decodeFields(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			var mount llbMount
+			for _, mf := range mountFields {
+				switch mf.num {
+				case 1:
+					mount.input = mf.int()
+				case 3:
+					mount.dest = mf.string()
+				case 4:
+					mount.output = mf.int()
+				case 5:
+					mount.readonly = mf.varint != 0
+				case 6:
+					mount.mountType = mf.int()
+				}
+			}
+			exec.mounts = append(exec.mounts, mount)
+		}
+	}
+	return exec, nil
+}
+
+func decodeFileOp(data []byte) ([]llbFileAction, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []llbFileAction
+	for _, f := range fields {
+		if f.num != 2 {
+			continue
+		}
+		actionFields, err := decodeFields(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var action llbFileAction
+		for _, af := range actionFields {
+			switch af.num {
+			case 1:
+				action.input = af.int()
+			case 2:
+				action.secondaryInput = af.int()
+			case 3:
+				action.output = af.int()
+			case 4, 5, 6, 7:
+				action.kind = map[protowire.Number]string{4: "copy", 5: "mkfile", 6: "mkdir", 7: "rm"}[af.num]
+				detail, err := decodeFields(af.bytes)
+				if err != nil {
+					return nil, err
+				}
+				for _, df := range detail {
+					switch {
+					case action.kind == "copy" && df.num == 1:
+						action.src = df.string()
+					case action.kind == "copy" && df.num == 2:
+						action.dest = df.string()
+					case action.kind != "copy" && df.num == 1:
+						action.path = df.string()
+					case action.kind == "mkdir" && df.num == 3:
+						action.makeParents = df.varint != 0
+					}
+				}
+			}
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// localPath joins a path from an LLB copy onto a local source directory,
+// keeping it inside the directory.
+func localPath(dir, path string) string {
+	return filepath.Join(dir, filepath.Clean("/"+strings.TrimPrefix(path, "/")))
+}
@@ -0,0 +1,329 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/internal/dockerignore"
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+var errNotChain = fmt.Errorf("multi-stage LLB (a state used twice, or more than one base image) is not supported yet")
+
+// translator turns the LLB a frontend returned into OSSB operations. OSSB
+// steps form a single chain on top of one base image, so only LLB of that
+// shape is accepted: one image (or scratch) source, exec ops whose only
+// mount is the root filesystem, and file ops that copy from a local source.
+type translator struct {
+	def        *llbDefinition
+	locals     map[string]string
+	operations []*types.Operation
+	seen       map[llbInput]bool
+}
+
+func translate(def *llbDefinition, locals map[string]string, imageConfig []byte) ([]*types.Operation, error) {
+	t := &translator{
+		def:    def,
+		locals: locals,
+		seen:   make(map[llbInput]bool),
+	}
+
+	dir, err := t.state(def.final)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		return nil, fmt.Errorf("frontend returned a local source instead of an image")
+	}
+
+	if len(imageConfig) > 0 {
+		if err := t.imageConfig(imageConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.operations, nil
+}
+
+// state emits the operations that produce input. A local source produces
+// no operation; its host directory is returned instead.
+func (t *translator) state(input llbInput) (string, error) {
+	op, exists := t.def.ops[input.digest]
+	if !exists {
+		return "", fmt.Errorf("LLB references unknown vertex %s", input.digest)
+	}
+
+	if dir, isLocal, err := t.local(op); isLocal {
+		return dir, err
+	}
+
+	if t.seen[input] {
+		return "", errNotChain
+	}
+	t.seen[input] = true
+
+	switch op.kind {
+	case "source":
+		image, found := strings.CutPrefix(op.identifier, "docker-image://")
+		if !found {
+			return "", fmt.Errorf("unsupported LLB source %s", op.identifier)
+		}
+		return "", t.source(image)
+	case "exec":
+		return "", t.exec(op, input.index)
+	case "file":
+		for i, action := range op.file {
+			if action.output == input.index {
+				return "", t.fileAction(op, i)
+			}
+		}
+		return "", fmt.Errorf("LLB file op has no output %d", input.index)
+	default:
+		return "", fmt.Errorf("LLB %s ops are not supported", op.kind)
+	}
+}
+
+func (t *translator) local(op *llbOp) (string, bool, error) {
+	name, found := strings.CutPrefix(op.identifier, "local://")
+	if op.kind != "source" || !found {
+		return "", false, nil
+	}
+
+	dir, exists := t.locals[name]
+	if !exists {
+		return "", true, fmt.Errorf("unknown local source %q", name)
+	}
+	return dir, true, nil
+}
+
+func (t *translator) source(image string) error {
+	if len(t.operations) > 0 {
+		return errNotChain
+	}
+
+	t.operations = append(t.operations, &types.Operation{
+		Type: types.OperationTypeSource,
+		Metadata: map[string]string{
+			"image": image,
+		},
+		Outputs: []string{"base"},
+	})
+	return nil
+}
+
+// base emits the root filesystem an op builds on: one of its inputs, or
+// scratch for index -1.
+func (t *translator) base(op *llbOp, index int64) error {
+	if index < 0 {
+		return t.source("scratch")
+	}
+	if index >= int64(len(op.inputs)) {
+		return fmt.Errorf("LLB op refers to missing input %d", index)
+	}
+
+	dir, err := t.state(op.inputs[index])
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		return fmt.Errorf("a local source can't be used as a root filesystem")
+	}
+	return nil
+}
+
+func (t *translator) exec(op *llbOp, output int64) error {
+	var root *llbMount
+	for i, mount := range op.exec.mounts {
+		if mount.dest != "/" {
+			return fmt.Errorf("RUN mounts are not supported (mount at %s)", mount.dest)
+		}
+		root = &op.exec.mounts[i]
+	}
+	if root == nil || root.output != output {
+		return fmt.Errorf("LLB exec op has no root filesystem output")
+	}
+
+	if err := t.base(op, root.input); err != nil {
+		return err
+	}
+
+	env := make(map[string]string)
+	for _, entry := range op.exec.env {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+
+	t.emit(&types.Operation{
+		Type:        types.OperationTypeExec,
+		Command:     op.exec.args,
+		Environment: env,
+		WorkDir:     valueOr(op.exec.cwd, "/"),
+		User:        valueOr(op.exec.user, "root"),
+	})
+	return nil
+}
+
+func (t *translator) fileAction(op *llbOp, i int) error {
+	action := op.file[i]
+
+	// Inputs past the op's own refer to the results of earlier actions.
+	if index := action.input - int64(len(op.inputs)); index >= 0 {
+		if index >= int64(i) {
+			return fmt.Errorf("LLB file action refers to a later action")
+		}
+		if err := t.fileAction(op, int(index)); err != nil {
+			return err
+		}
+	} else if err := t.base(op, action.input); err != nil {
+		return err
+	}
+
+	switch action.kind {
+	case "copy":
+		dir, err := t.copySource(op, action.secondaryInput)
+		if err != nil {
+			return err
+		}
+		source := localPath(dir, action.src)
+		matcher, _ := dockerignore.New(nil)
+		digest, err := matcher.Digest(dir, []string{source})
+		if err != nil {
+			return err
+		}
+		t.emit(&types.Operation{
+			Type:    types.OperationTypeFile,
+			Command: []string{"copy"},
+			Inputs:  []string{source},
+			WorkDir: "/",
+			User:    "root",
+			Metadata: map[string]string{
+				"dest":    action.dest,
+				"content": digest,
+			},
+			Context: dir,
+		})
+	case "mkdir":
+		command := []string{"mkdir", action.path}
+		if action.makeParents {
+			command = []string{"mkdir", "-p", action.path}
+		}
+		t.emit(&types.Operation{Type: types.OperationTypeExec, Command: command, WorkDir: "/", User: "root"})
+	case "rm":
+		t.emit(&types.Operation{Type: types.OperationTypeExec, Command: []string{"rm", "-rf", action.path}, WorkDir: "/", User: "root"})
+	default:
+		return fmt.Errorf("LLB %s actions are not supported", action.kind)
+	}
+	return nil
+}
+
+// copySource resolves the source of a copy, which has to be a local
+// source: copying from another stage would need a second chain.
+func (t *translator) copySource(op *llbOp, index int64) (string, error) {
+	if index < 0 || index >= int64(len(op.inputs)) {
+		return "", fmt.Errorf("copying from another build stage is not supported yet")
+	}
+
+	source, exists := t.def.ops[op.inputs[index].digest]
+	if !exists {
+		return "", fmt.Errorf("LLB references unknown vertex %s", op.inputs[index].digest)
+	}
+	dir, isLocal, err := t.local(source)
+	if !isLocal {
+		return "", fmt.Errorf("copying from another build stage is not supported yet")
+	}
+	return dir, err
+}
+
+// emit appends an operation that builds on the previous one, the way the
+// Dockerfile frontend chains its steps.
+func (t *translator) emit(op *types.Operation) {
+	inputs := []string{}
+	if len(t.operations) > 0 {
+		inputs = append(inputs, t.operations[len(t.operations)-1].Outputs...)
+	}
+	op.Inputs = append(inputs, op.Inputs...)
+
+	prefix := "layer"
+	if op.Type == types.OperationTypeMeta {
+		prefix = "meta"
+	}
+	op.Outputs = []string{fmt.Sprintf("%s-%d", prefix, len(t.operations))}
+
+	t.operations = append(t.operations, op)
+}
+
+// imageConfig turns the image config the frontend returned
+// (containerimage.config) into the meta steps the exporters read.
+func (t *translator) imageConfig(data []byte) error {
+	var image struct {
+		Config struct {
+			User         string
+			Env          []string
+			Entrypoint   []string
+			Cmd          []string
+			WorkingDir   string
+			Labels       map[string]string
+			ExposedPorts map[string]struct{}
+			Volumes      map[string]struct{}
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &image); err != nil {
+		return fmt.Errorf("invalid image config from frontend: %v", err)
+	}
+	config := image.Config
+
+	if len(config.Env) > 0 {
+		env := make(map[string]string)
+		for _, entry := range config.Env {
+			key, value, _ := strings.Cut(entry, "=")
+			env[key] = value
+		}
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Environment: env, Metadata: map[string]string{"type": "env"}})
+	}
+	if config.WorkingDir != "" {
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Metadata: map[string]string{"workdir": config.WorkingDir}})
+	}
+	if config.User != "" {
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, User: config.User, Metadata: map[string]string{"user": config.User}})
+	}
+	if len(config.Labels) > 0 {
+		metadata := map[string]string{"type": "label"}
+		for key, value := range config.Labels {
+			metadata["label."+key] = value
+		}
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Metadata: metadata})
+	}
+	if len(config.ExposedPorts) > 0 {
+		var ports []string
+		for port := range config.ExposedPorts {
+			ports = append(ports, strings.TrimSuffix(port, "/tcp"))
+		}
+		sort.Strings(ports)
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Metadata: map[string]string{"expose": strings.Join(ports, ",")}})
+	}
+	if len(config.Volumes) > 0 {
+		var volumes []string
+		for volume := range config.Volumes {
+			volumes = append(volumes, volume)
+		}
+		sort.Strings(volumes)
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Metadata: map[string]string{"volume": strings.Join(volumes, ",")}})
+	}
+	if len(config.Entrypoint) > 0 {
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Command: config.Entrypoint, Metadata: map[string]string{"entrypoint": strings.Join(config.Entrypoint, " ")}})
+	}
+	if len(config.Cmd) > 0 {
+		t.emit(&types.Operation{Type: types.OperationTypeMeta, Command: config.Cmd, Metadata: map[string]string{"cmd": strings.Join(config.Cmd, " ")}})
+	}
+
+	return nil
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
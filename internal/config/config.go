@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+const (
+	EnvConfigPath    = "OSSB_CONFIG"
+	SystemConfigPath = "/etc/ossb/config.json"
+)
+
+// Config holds operator settings that apply to every build on a host or
+// in a cluster, as opposed to per-build flags. In Kubernetes it is usually
+// mounted from a ConfigMap at /etc/ossb/config.json.
+type Config struct {
+	// AllowedExecutors restricts which executors builds may use. Empty
+	// means any registered executor is allowed.
+	AllowedExecutors []string `json:"allowed_executors,omitempty"`
+
+	// BaseImageMetadataURL is a service builds ask about the lifecycle of
+	// their base images, in addition to the built-in list.
+	BaseImageMetadataURL string `json:"base_image_metadata_url,omitempty"`
+
+	// Hooks maps lifecycle events (pre-build, post-step, on-failure,
+	// post-push) to the commands and webhooks to run for them. Hooks in
+	// the system config run for every build, ahead of any a user config
+	// adds.
+	Hooks map[string][]types.Hook `json:"hooks,omitempty"`
+
+	// TrustedTranscriptKeys pins the public keys (sha256 fingerprints, as
+	// printed by "ossb transcript verify") whose signatures on build
+	// transcripts are trusted.
+	TrustedTranscriptKeys []string `json:"trusted_transcript_keys,omitempty"`
+
+	path string
+}
+
+// Load reads the config file at path. With an empty path it uses
+// $OSSB_CONFIG, then ~/.ossb/config.json, then /etc/ossb/config.json, and
+// returns an empty config if none of them exist. The system config stays
+// authoritative when another file is used; see restrict.
+func Load(path string) (*Config, error) {
+	var system *Config
+	if _, err := os.Stat(SystemConfigPath); err == nil {
+		cfg, err := loadFile(SystemConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		system = cfg
+	}
+
+	if path == "" {
+		path = os.Getenv(EnvConfigPath)
+	}
+	if path == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			candidate := filepath.Join(homeDir, ".ossb", "config.json")
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+	}
+
+	if path == "" || filepath.Clean(path) == SystemConfigPath {
+		if system == nil {
+			return &Config{}, nil
+		}
+		return system, nil
+	}
+
+	cfg, err := loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if system != nil {
+		if err := cfg.restrict(system); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// restrict layers the operator's system config under a user config. The
+// executor allowlist can only be narrowed: the user config is intersected
+// with it, never replaces it. System hooks always run, with the user
+// config's hooks for the same event after them, so a user config can add
+// hooks but not drop the operator's. Other settings the user config
+// leaves out are taken from the system config.
+func (c *Config) restrict(system *Config) error {
+	if len(system.AllowedExecutors) > 0 {
+		if len(c.AllowedExecutors) == 0 {
+			c.AllowedExecutors = system.AllowedExecutors
+		} else {
+			var allowed []string
+			for _, executor := range c.AllowedExecutors {
+				for _, permitted := range system.AllowedExecutors {
+					if executor == permitted {
+						allowed = append(allowed, executor)
+						break
+					}
+				}
+			}
+			if len(allowed) == 0 {
+				return fmt.Errorf("config file %s allows none of the executors allowed by %s", c.path, system.path)
+			}
+			c.AllowedExecutors = allowed
+		}
+	}
+
+	if c.BaseImageMetadataURL == "" {
+		c.BaseImageMetadataURL = system.BaseImageMetadataURL
+	}
+	if len(system.Hooks) > 0 {
+		hooks := make(map[string][]types.Hook)
+		for event, systemHooks := range system.Hooks {
+			hooks[event] = append(append([]types.Hook{}, systemHooks...), c.Hooks[event]...)
+		}
+		for event, userHooks := range c.Hooks {
+			if _, exists := hooks[event]; !exists {
+				hooks[event] = userHooks
+			}
+		}
+		c.Hooks = hooks
+	}
+	if c.TrustedTranscriptKeys == nil {
+		c.TrustedTranscriptKeys = system.TrustedTranscriptKeys
+	}
+
+	return nil
+}
+
+func loadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	cfg.path = path
+
+	return &cfg, nil
+}
+
+// Path returns the file the config was loaded from, or "" if none was found.
+func (c *Config) Path() string {
+	return c.path
+}
@@ -0,0 +1,245 @@
+package dockerignore
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ResolveFile returns the ignore file that applies to a build: a
+// <Dockerfile>.dockerignore next to the Dockerfile wins over the context's
+// .dockerignore, so Dockerfiles sharing a context can ignore different
+// files. It returns "" if neither exists.
+func ResolveFile(contextDir, dockerfile string) string {
+	dockerfilePath := dockerfile
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(contextDir, dockerfile)
+	}
+
+	candidates := []string{
+		dockerfilePath + ".dockerignore",
+		filepath.Join(contextDir, ".dockerignore"),
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// ReadFile returns the patterns in an ignore file, without comments and
+// blank lines.
+func ReadFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return patterns, nil
+}
+
+type pattern struct {
+	re        *regexp.Regexp
+	exclusion bool
+}
+
+// Matcher applies .dockerignore patterns with Docker's semantics: the last
+// matching pattern wins, "!" re-includes, "**" spans directories, and a
+// pattern matching a directory also matches everything under it.
+type Matcher struct {
+	patterns []pattern
+}
+
+func New(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, raw := range patterns {
+		exclusion := strings.HasPrefix(raw, "!")
+		raw = strings.TrimSpace(strings.TrimPrefix(raw, "!"))
+
+		cleaned := filepath.ToSlash(filepath.Clean(raw))
+		cleaned = strings.TrimPrefix(cleaned, "/")
+		if cleaned == "." || cleaned == "" {
+			continue
+		}
+
+		re, err := compile(cleaned)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %q: %v", raw, err)
+		}
+		m.patterns = append(m.patterns, pattern{re: re, exclusion: exclusion})
+	}
+	return m, nil
+}
+
+// Excluded reports whether relPath, relative to the build context, is
+// ignored.
+func (m *Matcher) Excluded(relPath string) bool {
+	relPath = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(relPath)), "/")
+	if relPath == "." || relPath == "" {
+		return false
+	}
+
+	parents := []string{relPath}
+	for dir := filepath.ToSlash(filepath.Dir(relPath)); dir != "." && dir != "/"; dir = filepath.ToSlash(filepath.Dir(dir)) {
+		parents = append(parents, dir)
+	}
+
+	excluded := false
+	for _, p := range m.patterns {
+		for _, candidate := range parents {
+			if p.re.MatchString(candidate) {
+				excluded = !p.exclusion
+				break
+			}
+		}
+	}
+	return excluded
+}
+
+// HasExclusions reports whether any pattern re-includes paths, in which case
+// an excluded directory can't be skipped wholesale.
+func (m *Matcher) HasExclusions() bool {
+	for _, p := range m.patterns {
+		if p.exclusion {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(pat string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		switch {
+		case c == '*' && i+1 < len(pat) && pat[i+1] == '*':
+			i++
+			if i+1 < len(pat) && pat[i+1] == '/' {
+				// "**/" matches zero or more directories.
+				i++
+				expr.WriteString("(.*/)?")
+			} else {
+				expr.WriteString(".*")
+			}
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pat[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pat[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(pat):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(pat[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// Digest fingerprints what copying sources out of contextDir produces: the
+// context-relative path, mode and content of everything the patterns
+// don't exclude. COPY and ADD steps are cached on it rather than on where
+// the context lives, so editing a copied file invalidates the step.
+func (m *Matcher) Digest(contextDir string, sources []string) (string, error) {
+	return m.walk(contextDir, sources, true)
+}
+
+// StatDigest is a cheap stand-in for Digest that reads no file content:
+// it covers the same files' paths, modes, sizes and modification times.
+// It changes whenever an edit is visible in the file metadata, so it can
+// tell whether a Digest computed earlier still holds.
+func (m *Matcher) StatDigest(contextDir string, sources []string) (string, error) {
+	return m.walk(contextDir, sources, false)
+}
+
+func (m *Matcher) walk(contextDir string, sources []string, content bool) (string, error) {
+	hash := sha256.New()
+	for _, source := range sources {
+		rel, err := filepath.Rel(contextDir, source)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("source %s is outside the build context", source)
+		}
+
+		if _, err := os.Lstat(source); err != nil {
+			// The step itself reports the missing source.
+			fmt.Fprintf(hash, "missing %s\n", filepath.ToSlash(rel))
+			continue
+		}
+
+		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			contextRel, _ := filepath.Rel(contextDir, path)
+			if m.Excluded(contextRel) {
+				if info.IsDir() && !m.HasExclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			fmt.Fprintf(hash, "%s %o\n", filepath.ToSlash(contextRel), info.Mode())
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "-> %s\n", link)
+			case !content:
+				fmt.Fprintf(hash, "%d %d\n", info.Size(), info.ModTime().UnixNano())
+			case info.Mode().IsRegular():
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				fmt.Fprintf(hash, "%d\n", info.Size())
+				if _, err := io.Copy(hash, file); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %v", rel, err)
+		}
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,156 @@
+package ocilayout
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+const (
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifests = "application/vnd.docker.distribution.manifest.list.v2+json"
+	refNameAnnotation        = "org.opencontainers.image.ref.name"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *types.Platform   `json:"platform,omitempty"`
+}
+
+// Manifest is an image manifest or, with Manifests set, an image index.
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    Descriptor   `json:"config"`
+	Layers    []Descriptor `json:"layers"`
+	Manifests []Descriptor `json:"manifests"`
+}
+
+type ImageConfig struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Env []string `json:"Env"`
+	} `json:"config"`
+}
+
+// ResolveDigest returns the digest of the image manifest ref (a tag or
+// digest; may be empty if the layout holds a single image) refers to in an
+// OCI layout. Multi-platform indexes resolve to the manifest matching
+// platform.
+func ResolveDigest(layoutDir, ref string, platform types.Platform) (string, error) {
+	descriptor, _, err := Resolve(layoutDir, ref, platform)
+	if err != nil {
+		return "", err
+	}
+	return descriptor.Digest, nil
+}
+
+// Resolve returns the image manifest ref refers to for platform, and the
+// descriptor pointing at it; see ResolveDigest.
+func Resolve(layoutDir, ref string, platform types.Platform) (Descriptor, *Manifest, error) {
+	descriptor, err := selectManifest(layoutDir, ref, platform)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+
+	for {
+		var manifest Manifest
+		if err := ReadBlob(layoutDir, descriptor.Digest, &manifest); err != nil {
+			return Descriptor{}, nil, err
+		}
+		if manifest.MediaType != mediaTypeOCIIndex && manifest.MediaType != mediaTypeDockerManifests && len(manifest.Manifests) == 0 {
+			return descriptor, &manifest, nil
+		}
+
+		found := false
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil && platform.Matches(m.Platform.Normalize()) {
+				descriptor, found = m, true
+				break
+			}
+		}
+		if !found {
+			return Descriptor{}, nil, fmt.Errorf("no image for %s in %s", platform.String(), descriptor.Digest)
+		}
+	}
+}
+
+func selectManifest(layoutDir, ref string, platform types.Platform) (Descriptor, error) {
+	if strings.HasPrefix(ref, "sha256:") {
+		return Descriptor{Digest: ref}, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("not an OCI layout: %v", err)
+	}
+
+	var index Manifest
+	if err := json.Unmarshal(data, &index); err != nil {
+		return Descriptor{}, fmt.Errorf("invalid index.json: %v", err)
+	}
+
+	if ref == "" {
+		if len(index.Manifests) != 1 {
+			return Descriptor{}, fmt.Errorf("layout holds %d images; pick one with :tag or @digest", len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+
+	// Other tools may give a tag an entry per platform; anything else,
+	// including the nested index OSSB tags multi-platform images with, is
+	// checked against the platform once it is loaded.
+	var found []Descriptor
+	for _, m := range index.Manifests {
+		if m.Annotations[refNameAnnotation] != ref {
+			continue
+		}
+		if m.Platform == nil || platform.Matches(m.Platform.Normalize()) {
+			return m, nil
+		}
+		found = append(found, m)
+	}
+	if len(found) > 0 {
+		return found[0], nil
+	}
+	return Descriptor{}, fmt.Errorf("no image tagged %q in layout", ref)
+}
+
+// ReadBlob decodes a JSON blob after checking it matches its digest, so a
+// digest-pinned FROM can't silently pick up modified content.
+func ReadBlob(layoutDir, digest string, v interface{}) error {
+	path, err := BlobPath(layoutDir, digest)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("missing blob %s: %v", digest, err)
+	}
+	if got := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); got != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, got)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid blob %s: %v", digest, err)
+	}
+	return nil
+}
+
+// BlobPath returns where a blob lives in the layout, refusing digests that
+// could point outside it.
+func BlobPath(layoutDir, digest string) (string, error) {
+	algorithm, hex, found := strings.Cut(digest, ":")
+	if !found || algorithm != "sha256" || hex == "" || strings.ContainsAny(hex, "/.") {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return filepath.Join(layoutDir, "blobs", algorithm, hex), nil
+}
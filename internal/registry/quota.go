@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// QuotaError means the registry refused a push because the project
+// or account is out of storage. Retrying won't help, so pushes stop at the
+// first one.
+type QuotaError struct {
+	Repository string
+	Status     string
+	Message    string
+	Limit      string
+	Usage      string
+}
+
+func (e *QuotaError) Error() string {
+	msg := fmt.Sprintf("registry storage quota exceeded for %s", e.Repository)
+	if e.Status != "" {
+		msg += " (" + e.Status + ")"
+	}
+	if e.Usage != "" && e.Limit != "" {
+		msg += fmt.Sprintf(": %s used of %s", e.Usage, e.Limit)
+	} else if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg + "; delete unused tags or images in the repository, or ask the registry administrator to raise the quota"
+}
+
+var (
+	quotaLimitPattern = regexp.MustCompile(`(?i)(?:upper limit|limit|quota) of ([0-9.]+\s*[KMGTP]?i?B)`)
+	quotaUsagePattern = regexp.MustCompile(`(?i)(?:current usage|usage) of ([0-9.]+\s*[KMGTP]?i?B)`)
+)
+
+// quotaError recognizes a quota rejection from a registry response: an
+// error whose message is about quota or storage (Harbor project quotas,
+// GHCR and Docker Hub limits). The status alone doesn't decide it; a bare
+// 413 usually comes from a proxy's body size limit, not a quota.
+func quotaError(repository string, resp *http.Response, body []byte) *QuotaError {
+	message := strings.TrimSpace(string(body))
+
+	var distribution struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &distribution) == nil && len(distribution.Errors) > 0 {
+		message = distribution.Errors[0].Message
+		for _, e := range distribution.Errors {
+			if isQuotaMessage(e.Code + " " + e.Message) {
+				message = e.Message
+				break
+			}
+		}
+	}
+
+	if !isQuotaMessage(string(body)) {
+		return nil
+	}
+
+	return newQuotaError(repository, resp.Status, message)
+}
+
+func newQuotaError(repository, status, message string) *QuotaError {
+	e := &QuotaError{
+		Repository: repository,
+		Status:     status,
+		Message:    message,
+	}
+	if match := quotaLimitPattern.FindStringSubmatch(message); match != nil {
+		e.Limit = match[1]
+	}
+	if match := quotaUsagePattern.FindStringSubmatch(message); match != nil {
+		e.Usage = match[1]
+	}
+	return e
+}
+
+func isQuotaMessage(message string) bool {
+	lower := strings.ToLower(message)
+	return strings.Contains(lower, "quota") ||
+		strings.Contains(lower, "storage limit") ||
+		strings.Contains(lower, "exceed the configured upper limit")
+}
@@ -0,0 +1,378 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EnvRegistryUsername = "OSSB_REGISTRY_USERNAME"
+	EnvRegistryPassword = "OSSB_REGISTRY_PASSWORD"
+	EnvRegistryInsecure = "OSSB_REGISTRY_INSECURE"
+
+	// maxManifestSize bounds what is read for manifests, indexes and
+	// image configs, which are small JSON documents.
+	maxManifestSize = 4 << 20
+)
+
+// Client talks to a single repository over the OCI distribution
+// API. It covers what pushing needs (blob existence checks, blob uploads
+// and manifest puts) plus the manifest and blob reads that resolving a
+// base image's config needs.
+type Client struct {
+	host       string
+	repository string
+	scheme     string
+	username   string
+	password   string
+	actions    string
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewClient returns a client for the repository part of ref, e.g.
+// "registry.example.com/team/app:1.0". Credentials come from
+// OSSB_REGISTRY_USERNAME and OSSB_REGISTRY_PASSWORD.
+func NewClient(ref string) (*Client, string, error) {
+	name, reference := SplitReference(ref)
+
+	host, repository := "registry-1.docker.io", name
+	if idx := strings.Index(name, "/"); idx > 0 {
+		first := name[:idx]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, repository = first, name[idx+1:]
+		}
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = "registry-1.docker.io"
+	}
+	if host == "registry-1.docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	if repository == "" {
+		return nil, "", fmt.Errorf("invalid image reference %q", ref)
+	}
+
+	scheme := "https"
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") || os.Getenv(EnvRegistryInsecure) == "true" {
+		scheme = "http"
+	}
+
+	return &Client{
+		host:       host,
+		repository: repository,
+		scheme:     scheme,
+		username:   os.Getenv(EnvRegistryUsername),
+		password:   os.Getenv(EnvRegistryPassword),
+		actions:    "pull,push",
+		httpClient: &http.Client{Timeout: 30 * time.Minute},
+	}, reference, nil
+}
+
+// SplitReference splits an image reference into its repository and its
+// tag or digest, defaulting to "latest".
+func SplitReference(ref string) (string, string) {
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		return ref[:idx], ref[idx+1:]
+	}
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return ref[:idx], ref[idx+1:]
+	}
+	return ref, "latest"
+}
+
+func (c *Client) Repository() string {
+	return c.host + "/" + c.repository
+}
+
+func (c *Client) BlobExists(digest string) (bool, error) {
+	resp, err := c.do(http.MethodHead, c.url("/blobs/"+digest), nil, "", 0, "")
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status checking blob %s: %s", digest, resp.Status)
+	}
+}
+
+// PushBlob uploads the file at path as blob digest in a single request,
+// unless the registry already has it. It reports whether it uploaded.
+func (c *Client) PushBlob(digest, path string) (bool, error) {
+	exists, err := c.BlobExists(digest)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	resp, err := c.do(http.MethodPost, c.url("/blobs/uploads/"), nil, "", 0, "")
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return false, c.registryError("start upload of "+digest, resp)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return false, fmt.Errorf("registry returned no upload location: %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open blob %s: %v", digest, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat blob %s: %v", digest, err)
+	}
+
+	resp, err = c.do(http.MethodPut, location.String(), file, "application/octet-stream", info.Size(), "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return false, c.registryError("upload "+digest, resp)
+	}
+
+	return true, nil
+}
+
+func (c *Client) PushManifest(reference, mediaType string, data []byte) error {
+	resp, err := c.do(http.MethodPut, c.url("/manifests/"+reference), bytes.NewReader(data), mediaType, int64(len(data)), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return c.registryError("push manifest "+reference, resp)
+	}
+	return nil
+}
+
+// GetManifest fetches the manifest or index that reference (a tag or
+// digest) points to, returning its media type, content and digest. The
+// content is checked against the digest when reference is one.
+func (c *Client) GetManifest(reference string) (string, []byte, string, error) {
+	accept := strings.Join([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", ")
+
+	resp, err := c.do(http.MethodGet, c.url("/manifests/"+reference), nil, "", 0, accept)
+	if err != nil {
+		return "", nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, "", c.registryError("get manifest "+reference, resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return "", nil, "", fmt.Errorf("failed to read manifest %s: %v", reference, err)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return "", nil, "", fmt.Errorf("manifest %s does not match its digest (got %s)", reference, digest)
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	return mediaType, data, digest, nil
+}
+
+// GetBlob fetches a small blob, such as an image config, and checks it
+// against its digest.
+func (c *Client) GetBlob(digest string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, c.url("/blobs/"+digest), nil, "", 0, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.registryError("get blob "+digest, resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %v", digest, err)
+	}
+	if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != digest {
+		return nil, fmt.Errorf("blob %s does not match its digest", digest)
+	}
+	return data, nil
+}
+
+func (c *Client) url(path string) string {
+	return fmt.Sprintf("%s://%s/v2/%s%s", c.scheme, c.host, c.repository, path)
+}
+
+// do sends a request, answering a bearer-token challenge once if the
+// registry asks for one. Bodies must be seekable so they can be resent.
+func (c *Client) do(method, target string, body io.ReadSeeker, contentType string, length int64, accept string) (*http.Response, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if body != nil {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = body
+		}
+
+		req, err := http.NewRequest(method, target, reader)
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if body != nil {
+			req.ContentLength = length
+		}
+		c.authorize(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", method, c.host, err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("unreachable")
+}
+
+func (c *Client) authorize(req *http.Request) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+func (c *Client) authenticate(challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		if c.username == "" {
+			return fmt.Errorf("registry %s requires credentials (set %s and %s)", c.host, EnvRegistryUsername, EnvRegistryPassword)
+		}
+		return fmt.Errorf("registry %s rejected the supplied credentials", c.host)
+	}
+
+	params := parseChallenge(challenge[len("bearer "):])
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("registry %s sent a bearer challenge without a realm", c.host)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", c.repository, c.actions))
+
+	req, err := http.NewRequest(http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get registry token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.registryError("get registry token", resp)
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return fmt.Errorf("failed to parse registry token: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = tokenResponse.Token
+	if c.token == "" {
+		c.token = tokenResponse.AccessToken
+	}
+
+	return nil
+}
+
+func parseChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(challenge, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+// registryError turns a failed response into an error, reporting quota
+// rejections as a QuotaError so callers can stop instead of retrying.
+func (c *Client) registryError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if quota := quotaError(c.Repository(), resp, body); quota != nil {
+		return quota
+	}
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("failed to %s: request too large (proxy or registry size limit): %s", action, resp.Status)
+	}
+	return fmt.Errorf("failed to %s: %s: %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bibin-skaria/ossb/internal/types"
+)
+
+// ResolveImageConfig looks up an image in its registry and returns the
+// digest ref resolves to (an index digest for multi-platform images, as
+// BuildKit does) and the image config for platform.
+func ResolveImageConfig(ref string, platform types.Platform) (string, []byte, error) {
+	client, reference, err := NewClient(ref)
+	if err != nil {
+		return "", nil, err
+	}
+	client.actions = "pull"
+
+	mediaType, data, digest, err := client.GetManifest(reference)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if isIndexMediaType(mediaType, data) {
+		var index struct {
+			Manifests []struct {
+				Digest   string `json:"digest"`
+				Platform *struct {
+					OS           string `json:"os"`
+					Architecture string `json:"architecture"`
+					Variant      string `json:"variant"`
+				} `json:"platform"`
+			} `json:"manifests"`
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return "", nil, fmt.Errorf("invalid index for %s: %v", ref, err)
+		}
+
+		manifestDigest := ""
+		for _, manifest := range index.Manifests {
+			if manifest.Platform == nil {
+				continue
+			}
+			candidate := types.Platform{OS: manifest.Platform.OS, Architecture: manifest.Platform.Architecture, Variant: manifest.Platform.Variant}
+			if platform.Matches(candidate) {
+				manifestDigest = manifest.Digest
+				break
+			}
+		}
+		if manifestDigest == "" {
+			return "", nil, fmt.Errorf("%s has no image for %s", ref, platform.String())
+		}
+
+		if _, data, _, err = client.GetManifest(manifestDigest); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, fmt.Errorf("invalid manifest for %s: %v", ref, err)
+	}
+	if manifest.Config.Digest == "" {
+		return "", nil, fmt.Errorf("manifest for %s has no config", ref)
+	}
+
+	config, err := client.GetBlob(manifest.Config.Digest)
+	if err != nil {
+		return "", nil, err
+	}
+	return digest, config, nil
+}
+
+// isIndexMediaType reports whether a manifest response is an index. Some
+// registries send a generic content type, so the document's own mediaType
+// (or a manifests list) decides then.
+func isIndexMediaType(mediaType string, data []byte) bool {
+	switch mediaType {
+	case "application/vnd.oci.image.index.v1+json", "application/vnd.docker.distribution.manifest.list.v2+json":
+		return true
+	case "application/vnd.oci.image.manifest.v1+json", "application/vnd.docker.distribution.manifest.v2+json":
+		return false
+	}
+
+	var document struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return false
+	}
+	if document.MediaType != "" {
+		return document.MediaType == "application/vnd.oci.image.index.v1+json" ||
+			document.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json"
+	}
+	return document.Manifests != nil
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"runtime"
@@ -18,6 +20,15 @@ const (
 	OperationTypeMeta   OperationType = "meta"
 )
 
+// Local sources seed a stage's root filesystem from the host instead of
+// pulling an image: FROM dir:///path/to/rootfs or
+// FROM oci-layout://./layout@sha256:... (or :tag). The frontend records
+// which one in the source operation's "source" metadata.
+const (
+	SourceDir       = "dir"
+	SourceOCILayout = "oci-layout"
+)
+
 type Platform struct {
 	OS           string `json:"os"`
 	Architecture string `json:"architecture"`
@@ -31,6 +42,22 @@ func (p Platform) String() string {
 	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
 }
 
+// TagSuffix returns the architecture suffix used for per-platform tags,
+// e.g. "amd64", "arm64" or "armv7".
+func (p Platform) TagSuffix() string {
+	return p.Architecture + p.Variant
+}
+
+// PlatformTag appends the platform's suffix to an image reference's tag,
+// defaulting the tag to "latest" when the reference has none.
+func PlatformTag(ref string, p Platform) string {
+	name, tag := ref, "latest"
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		name, tag = ref[:idx], ref[idx+1:]
+	}
+	return fmt.Sprintf("%s:%s-%s", name, tag, p.TagSuffix())
+}
+
 func ParsePlatform(platform string) Platform {
 	parts := strings.Split(platform, "/")
 	if len(parts) < 2 {
@@ -46,14 +73,93 @@ func ParsePlatform(platform string) Platform {
 		p.Variant = parts[2]
 	}
 	
+	return p.Normalize()
+}
+
+// Normalize maps architecture aliases to their OCI names and fills in the
+// variant the same way registries select manifests: plain "arm" means
+// arm/v7, and "arm64/v8" is just arm64.
+func (p Platform) Normalize() Platform {
+	p.OS = strings.ToLower(p.OS)
+	p.Architecture = strings.ToLower(p.Architecture)
+	p.Variant = strings.ToLower(p.Variant)
+
+	switch p.Architecture {
+	case "x86_64", "x86-64":
+		p.Architecture = "amd64"
+		p.Variant = ""
+	case "i386", "i486", "i586", "i686":
+		p.Architecture = "386"
+		p.Variant = ""
+	case "aarch64", "arm64":
+		p.Architecture = "arm64"
+		if p.Variant == "8" || p.Variant == "v8" {
+			p.Variant = ""
+		}
+	case "armhf", "armv7", "armv7l":
+		p.Architecture = "arm"
+		p.Variant = "v7"
+	case "armel", "armv6", "armv6l":
+		p.Architecture = "arm"
+		p.Variant = "v6"
+	case "armv5", "armv5l", "armv5tel":
+		p.Architecture = "arm"
+		p.Variant = "v5"
+	case "arm":
+		switch p.Variant {
+		case "":
+			p.Variant = "v7"
+		case "5", "6", "7", "8":
+			p.Variant = "v" + p.Variant
+		}
+	}
+
 	return p
 }
 
+// Matches reports whether an image built for other can run as p. An image
+// that doesn't declare a variant is accepted for any variant of its
+// architecture, since many arm32 images omit it from their config.
+func (p Platform) Matches(other Platform) bool {
+	p, other = p.Normalize(), other.Normalize()
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return other.Variant == "" || p.Variant == other.Variant
+}
+
 func GetHostPlatform() Platform {
-	return Platform{
+	p := Platform{
 		OS:           runtime.GOOS,
 		Architecture: runtime.GOARCH,
 	}
+	if p.Architecture == "arm" {
+		p.Variant = hostArmVariant()
+	}
+	return p.Normalize()
+}
+
+// hostArmVariant reads the CPU architecture version of a 32-bit ARM host
+// from /proc/cpuinfo, since GOARCH alone doesn't say v6 or v7.
+func hostArmVariant() string {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(key) != "CPU architecture" {
+			continue
+		}
+		version := strings.TrimSpace(value)
+		if version == "" || version == "AArch64" {
+			return ""
+		}
+		return "v" + version
+	}
+
+	return ""
 }
 
 func GetSupportedPlatforms() []Platform {
@@ -81,9 +187,25 @@ type Operation struct {
 	WorkDir     string            `json:"workdir,omitempty"`
 	User        string            `json:"user,omitempty"`
 	Platform    Platform          `json:"platform,omitempty"`
+
+	// Context is the build context directory of a step that copies from
+	// it. The cache key uses context-relative paths instead, so the same
+	// files copied from a context elsewhere share the cache.
+	Context string `json:"context,omitempty"`
 }
 
 func (o *Operation) CacheKey() string {
+	inputs := o.Inputs
+	if o.Context != "" {
+		inputs = make([]string, len(o.Inputs))
+		for i, input := range o.Inputs {
+			inputs[i] = input
+			if rel, err := filepath.Rel(o.Context, input); err == nil && filepath.IsAbs(input) && !strings.HasPrefix(rel, "..") {
+				inputs[i] = "context:" + filepath.ToSlash(rel)
+			}
+		}
+	}
+
 	data := struct {
 		Type        OperationType     `json:"type"`
 		Command     []string          `json:"command,omitempty"`
@@ -96,7 +218,7 @@ func (o *Operation) CacheKey() string {
 	}{
 		Type:        o.Type,
 		Command:     o.Command,
-		Inputs:      o.Inputs,
+		Inputs:      inputs,
 		Environment: o.Environment,
 		Metadata:    o.Metadata,
 		WorkDir:     o.WorkDir,
@@ -115,6 +237,9 @@ type OperationResult struct {
 	Error       string            `json:"error,omitempty"`
 	Outputs     []string          `json:"outputs,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+	Log         string            `json:"log,omitempty"`
 	CacheHit    bool              `json:"cache_hit"`
 }
 
@@ -290,6 +415,36 @@ type BuildConfig struct {
 	Push        bool              `json:"push,omitempty"`
 	Registry    string            `json:"registry,omitempty"`
 	Rootless    bool              `json:"rootless,omitempty"`
+
+	AllowPartialPlatforms bool                         `json:"allow_partial_platforms,omitempty"`
+	PlatformBuildArgs     map[string]map[string]string `json:"platform_build_args,omitempty"`
+	PlatformTagSuffix     bool                         `json:"platform_tag_suffix,omitempty"`
+	Limits                ResourceLimits               `json:"limits,omitempty"`
+	TranscriptPath        string                       `json:"transcript_path,omitempty"`
+	TranscriptKey         string                       `json:"transcript_key,omitempty"`
+	TimestampURL          string                       `json:"timestamp_url,omitempty"`
+	LayoutDir             string                       `json:"layout_dir,omitempty"`
+	Executor              string                       `json:"executor,omitempty"`
+	AllowedExecutors      []string                     `json:"allowed_executors,omitempty"`
+	StreamPush            bool                         `json:"stream_push,omitempty"`
+	ArtifactType          string                       `json:"artifact_type,omitempty"`
+	ArtifactPaths         []string                     `json:"artifact_paths,omitempty"`
+	ArtifactTags          []string                     `json:"artifact_tags,omitempty"`
+	BaseImageMetadataURL  string                       `json:"base_image_metadata_url,omitempty"`
+	Hooks                 map[string][]Hook            `json:"hooks,omitempty"`
+}
+
+type ResourceLimits struct {
+	Disk int64 `json:"disk,omitempty"`
+}
+
+// Hook is a user command or webhook run at a point in the build lifecycle.
+// Exactly one of Command and URL is set.
+type Hook struct {
+	Command   []string `json:"command,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Timeout   string   `json:"timeout,omitempty"`
+	OnFailure string   `json:"on_failure,omitempty"`
 }
 
 type CacheInfo struct {
@@ -298,6 +453,13 @@ type CacheInfo struct {
 	HitRate     float64 `json:"hit_rate"`
 	Hits        int64 `json:"hits"`
 	Misses      int64 `json:"misses"`
+
+	// Per-key lock contention seen by this process, and the locks
+	// currently held in the cache directory.
+	LockWaits      int64 `json:"lock_waits,omitempty"`
+	LockWaitMillis int64 `json:"lock_wait_ms,omitempty"`
+	LockSteals     int64 `json:"lock_steals,omitempty"`
+	HeldLocks      int   `json:"held_locks,omitempty"`
 }
 
 type PlatformResult struct {
@@ -305,22 +467,41 @@ type PlatformResult struct {
 	Success    bool              `json:"success"`
 	Error      string            `json:"error,omitempty"`
 	ImageID    string            `json:"image_id,omitempty"`
-	ManifestID string            `json:"manifest_id,omitempty"`
+	ManifestID string            `json:"manifest_id,omitempty"` // digest of the platform's manifest
 	Size       int64             `json:"size,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+}
+
+type StepResult struct {
+	Index          int           `json:"index"`
+	Platform       string        `json:"platform"`
+	Type           OperationType `json:"type"`
+	Summary        string        `json:"summary"`
+	CacheKey       string        `json:"cache_key"`
+	CacheHit       bool          `json:"cache_hit"`
+	Success        bool          `json:"success"`
+	DurationMillis int64         `json:"duration_ms"`
 }
 
 type BuildResult struct {
-	Success         bool                       `json:"success"`
-	Error           string                     `json:"error,omitempty"`
-	Operations      int                        `json:"operations"`
-	CacheHits       int                        `json:"cache_hits"`
-	Duration        string                     `json:"duration"`
-	OutputPath      string                     `json:"output_path,omitempty"`
-	ImageID         string                     `json:"image_id,omitempty"`
-	ManifestListID  string                     `json:"manifest_list_id,omitempty"`
-	Metadata        map[string]string          `json:"metadata,omitempty"`
-	PlatformResults map[string]*PlatformResult `json:"platform_results,omitempty"`
-	MultiArch       bool                       `json:"multi_arch,omitempty"`
+	Success          bool                       `json:"success"`
+	Cancelled        bool                       `json:"cancelled,omitempty"`
+	Error            string                     `json:"error,omitempty"`
+	Operations       int                        `json:"operations"`
+	CacheHits        int                        `json:"cache_hits"`
+	Duration         string                     `json:"duration"`
+	OutputPath       string                     `json:"output_path,omitempty"`
+	ImageID          string                     `json:"image_id,omitempty"`
+	ManifestListID   string                     `json:"manifest_list_id,omitempty"`
+	Digest           string                     `json:"digest,omitempty"` // manifest or index digest of the exported image
+	Metadata         map[string]string          `json:"metadata,omitempty"`
+	PlatformResults  map[string]*PlatformResult `json:"platform_results,omitempty"`
+	MultiArch        bool                       `json:"multi_arch,omitempty"`
+	Warnings         []string                   `json:"warnings,omitempty"`
+	SkippedPlatforms []string                   `json:"skipped_platforms,omitempty"`
+	BuildID          string                     `json:"build_id,omitempty"`
+	StartedAt        string                     `json:"started_at,omitempty"`
+	Steps            []*StepResult              `json:"steps,omitempty"`
 }
 
 type DockerfileInstruction struct {